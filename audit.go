@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// statusRecorder records the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// auditHandler logs every request served by next with the remote address,
+// user agent, duration, response code and the requested collectors and
+// datacenters, to account for access to the service inventory.
+func auditHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		query := r.URL.Query()
+		collectors := "all"
+		if c := query["collect[]"]; len(c) > 0 {
+			collectors = strings.Join(c, ",")
+		}
+		args := []interface{}{
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+			"path", r.URL.Path,
+			"collectors", collectors,
+			"datacenters", strings.Join(query["dc"], ","),
+			"code", rec.code,
+			"duration_seconds", time.Since(start).Seconds(),
+		}
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			args = append(args, "forwarded_for", fwd)
+		}
+		logger.Info("Metrics request", args...)
+	})
+}
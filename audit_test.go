@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuditHandler(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *slog.Logger) { logger = l }(logger)
+	logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	h := auditHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unknown collector", http.StatusBadRequest)
+	}))
+	req := httptest.NewRequest("GET", "/metrics?collect[]=kv&collect[]=raft&dc=dc1", nil)
+	req.Header.Set("User-Agent", "Prometheus/2.0")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, want := range []string{"user_agent=Prometheus/2.0", "collectors=kv,raft", "datacenters=dc1", "code=400", "remote_addr=192.0.2.1:1234"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in audit log %q", want, buf.String())
+		}
+	}
+}
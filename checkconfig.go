@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/prometheus/consul_exporter/pkg/exporter"
+)
+
+// checkTLSFiles checks that the TLS files of opts, if any, contain a valid CA
+// bundle and a matching certificate and key.
+func checkTLSFiles(opts exporter.ConsulOpts) error {
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return fmt.Errorf("consul.ca-file: %s", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return fmt.Errorf("consul.ca-file: no PEM-encoded certificates in %s", opts.CAFile)
+		}
+	}
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return fmt.Errorf("consul.cert-file and consul.key-file must be set together")
+	}
+	if opts.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile); err != nil {
+			return fmt.Errorf("consul.cert-file and consul.key-file: %s", err)
+		}
+	}
+	return nil
+}
+
+// checkRegexes checks that the regexes of the named flag compile.
+func checkRegexes(flag string, regexes ...string) error {
+	for _, re := range regexes {
+		if _, err := regexp.Compile(re); err != nil {
+			return fmt.Errorf("%s: %s", flag, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/consul_exporter/pkg/exporter"
+)
+
+func TestCheckTLSFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(ca, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		opts exporter.ConsulOpts
+		err  string
+	}{
+		{exporter.ConsulOpts{}, ""},
+		{exporter.ConsulOpts{CAFile: ca}, "no PEM-encoded certificates"},
+		{exporter.ConsulOpts{CAFile: ca + ".missing"}, "no such file"},
+		{exporter.ConsulOpts{CertFile: "client.pem"}, "must be set together"},
+		{exporter.ConsulOpts{CertFile: ca, KeyFile: ca}, "consul.cert-file and consul.key-file"},
+	}
+	for _, test := range cases {
+		err := checkTLSFiles(test.opts)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("expected error containing %q for %+v, got %v", test.err, test.opts, err)
+		}
+	}
+}
@@ -1,407 +1,423 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	_ "net/http/pprof"
-	"net/url"
-	"regexp"
-	"strconv"
+	"os"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/version"
 	"gopkg.in/alecthomas/kingpin.v2"
 
-	consul_api "github.com/hashicorp/consul/api"
+	"github.com/prometheus/consul_exporter/pkg/exporter"
 )
 
-const (
-	namespace = "consul"
-)
+// collectOnce writes the metrics of a single collection in the text format. It
+// returns an error if Consul wasn't reachable.
+func collectOnce(w io.Writer, e *exporter.Exporter) error {
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(e); err != nil {
+		return err
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		return err
+	}
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			return err
+		}
+	}
+	return e.Ready()
+}
 
-var (
-	up = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "up"),
-		"Was the last query of Consul successful.",
-		nil, nil,
-	)
-	clusterServers = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "raft_peers"),
-		"How many peers (servers) are in the Raft cluster.",
-		nil, nil,
-	)
-	clusterLeader = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "raft_leader"),
-		"Does Raft cluster have a leader (according to this node).",
-		nil, nil,
-	)
-	nodeCount = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "serf_lan_members"),
-		"How many members are in the cluster.",
-		[]string{"datacenter"}, nil,
-	)
-	serviceCount = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "catalog_services"),
-		"How many services are in the cluster.",
-		[]string{"datacenter"}, nil,
-	)
-	serviceTag = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "service_tag"),
-		"Tags of a service.",
-		[]string{"service_id", "node", "tag"}, nil,
+func main() {
+	var (
+		listenAddress = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9107").String()
+		grpcAddress   = kingpin.Flag("web.grpc-listen-address", "Address to serve the grpc.health.v1 health checking service on, reporting whether Consul was reachable during the last scrape. Disabled by default.").Default("").String()
+		metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+		metricsIncl   = kingpin.Flag("web.metrics-include", "Regex of metric names to serve, anchored at both ends. All by default.").Default("").String()
+		metricsExcl   = kingpin.Flag("web.metrics-exclude", "Regex of metric names not to serve, anchored at both ends.").Default("").String()
+		tagLabels     = kingpin.Flag("consul.service-tag-labels", "Add the service_name and datacenter labels to consul_service_tag. Off by default to keep the labels of existing series.").Bool()
+		healthSummary = kingpin.Flag("consul.health-summary", "Generate a health summary for each service instance. Needs n+1 queries with catalog.service-meta-key.").Default("true").Bool()
+		kvPrefix      = kingpin.Flag("kv.prefix", "Prefix from which to expose key/value pairs.").Default("").String()
+		kvFilter      = kingpin.Flag("kv.filter", "Regex that determines which keys to expose.").Default(".*").String()
+		kvInfo        = kingpin.Flag("kv.info", "Export non-numeric values as consul_catalog_kv_info series with the value as label.").Default("false").Bool()
+		kvBools       = kingpin.Flag("kv.bools", "Map boolean strings like true/false, on/off or enabled/disabled to 1 and 0.").Default("false").Bool()
+		kvFlags       = kingpin.Flag("kv.flags", "Export the Flags field of selected keys as consul_catalog_kv_flags.").Default("false").Bool()
+		kvModifyIndex = kingpin.Flag("kv.modify-index", "Export the ModifyIndex of selected keys as consul_catalog_kv_modify_index.").Default("false").Bool()
+		kvTimestamps  = kingpin.Flag("kv.timestamps", "Export values with the Unix timestamp stored in the companion key <key>/.ts as explicit timestamp.").Default("false").Bool()
+		kvDepth       = kingpin.Flag("kv.depth", "Only export keys up to this many levels below the prefix, 0 exports all keys.").Default("0").Int()
+		kvSkipDirs    = kingpin.Flag("kv.skip-dirs", "Skip directory placeholder keys ending with a slash.").Default("false").Bool()
+		kvDecode      = kingpin.Flag("kv.decode", "Decoding applied to values before parsing, in the order given (base64 or gzip). May be repeated.").Enums("base64", "gzip")
+		kvCounters    = kingpin.Flag("kv.counter-suffix", "Export keys ending with this suffix as the counter consul_catalog_kv_total.").Default("").String()
+		kvAllow       = kingpin.Flag("kv.allow", "Regex of keys that may be exported, anchored at both ends. May be repeated.").Strings()
+		kvSensitive   = kingpin.Flag("kv.sensitive", "Regex of keys, anchored at both ends, whose names and values are never logged, shown in the snapshot or exported as info series. May be repeated.").Strings()
+		kvDefaultDeny = kingpin.Flag("kv.default-deny", "Only export keys explicitly allowed by kv.allow or the allow lists of the configuration file.").Default("false").Bool()
+		kvWatch       = kingpin.Flag("kv.watch", "Watch the KV prefixes with blocking queries in the background and serve the cached pairs at scrape time.").Default("false").Bool()
+		kvTxn         = kingpin.Flag("kv.txn", "Read all KV prefixes in a single transaction, so that their values are from the same Raft index.").Default("false").Bool()
+		kvJSON        = kingpin.Flag("kv.json", "Flatten numeric fields of JSON values into one series per field, with the JSON path as label.").Default("false").Bool()
+		kvHCL         = kingpin.Flag("kv.hcl", "Flatten numeric fields of HCL values into one series per field, with the path as label.").Default("false").Bool()
+		kvYAML        = kingpin.Flag("kv.yaml", "Flatten numeric fields of YAML values into one series per field, with the path as label.").Default("false").Bool()
+		serverEnv     = kingpin.Flag("consul.server-from-env", "Environment variable, e.g. HOST_IP, whose value replaces the host of consul.server, keeping its scheme and port.").Default("").String()
+		maxRPS        = kingpin.Flag("consul.max-rps", "Maximum number of requests per second to the Consul API, 0 means unlimited.").Default("0").Float64()
+		agentCache    = kingpin.Flag("consul.agent-cache", "Serve reads from the cache of the Consul agent, which refreshes them in the background. Consistent reads bypass it.").Default("false").Bool()
+		cacheMaxAge   = kingpin.Flag("consul.agent-cache-max-age", "Maximum age of agent cache entries before they are refetched from the servers, 0 means no limit.").Default("0s").Duration()
+		dcsTTL        = kingpin.Flag("catalog.datacenters-ttl", "How long to cache the datacenters known to the catalog, 0 queries them at every scrape.").Default("5m").Duration()
+		catalogWatch  = kingpin.Flag("catalog.watch", "Watch the services, nodes and health checks of each datacenter with blocking queries in the background and serve the cached state at scrape time.").Default("false").Bool()
+		watchHealth   = kingpin.Flag("catalog.watch-service-health", "With catalog.watch, watch the health of every service with a blocking query per service instead of the whole health state, which agents with use_streaming_backend serve from Consul's streaming backend.").Default("false").Bool()
+		leaderKey     = kingpin.Flag("leader-election.key", "KV key of a lock that only the collecting one of several replicas holds, the others stand by. Empty disables leader election.").Default("").String()
+		shardIndex    = kingpin.Flag("shard.index", "Index of this replica among shard.total replicas splitting the services and nodes between them, from 0.").Default("0").Int()
+		shardTotal    = kingpin.Flag("shard.total", "Number of replicas splitting the services and nodes between them by the hash of their name, 0 or 1 disables sharding.").Default("0").Int()
+		maxServices   = kingpin.Flag("catalog.max-services", "Maximum number of services to collect per datacenter, 0 means unlimited.").Default("0").Int()
+		nodeMeta      = kingpin.Flag("catalog.node-meta-key", "Node metadata key to export as a label of consul_node_meta_info. Can be repeated.").Strings()
+		serviceMeta   = kingpin.Flag("catalog.service-meta-key", "Service metadata key to export as a label of consul_service_meta_info. Can be repeated.").Strings()
+		checkLabel    = kingpin.Flag("health.check-label", "Identifier of health checks populating the check label (id or name).").Default(exporter.CheckLabelID).Enum(exporter.CheckLabelID, exporter.CheckLabelName)
+		stateDuration = kingpin.Flag("health.check-state-duration", "Track the status transitions of health checks in memory and export how long each has been in its current status.").Bool()
+		checkType     = kingpin.Flag("health.check-type", "Add the check_type label, e.g. http, ttl or alias, to the health check metrics.").Bool()
+		tagBreakdown  = kingpin.Flag("health.tag-breakdown", "Tag, e.g. blue, green or canary, for which to count all and the passing instances of every service carrying it. Needs a query per service and tag. May be repeated.").Strings()
+		checksExclude = kingpin.Flag("health.checks-exclude", "Regex of check IDs to exclude from the node and service check series.").Default("").String()
+		configFile    = kingpin.Flag("config.file", "Path to an optional HCL configuration file.").Default("").String()
+		pushGateway   = kingpin.Flag("push.gateway-url", "URL of a Pushgateway to push the metrics to every push.interval.").Default("").String()
+		pushJob       = kingpin.Flag("push.job", "Job name of the metrics pushed to the Pushgateway.").Default("consul_exporter").String()
+		pushGrouping  = kingpin.Flag("push.grouping", "Additional grouping label of the metrics pushed to the Pushgateway, as name=value. May be repeated.").Strings()
+		pushTimeout   = kingpin.Flag("push.timeout", "Timeout of pushes to the Pushgateway or Graphite.").Default("30s").Duration()
+		pushTextfile  = kingpin.Flag("push.textfile", "Path of a .prom file to write the metrics to every push.interval, for node_exporter's textfile collector.").Default("").String()
+		graphiteAddr  = kingpin.Flag("push.graphite-address", "Address (host:port) of a Graphite server to send the metrics to every push.interval.").Default("").String()
+		graphitePfx   = kingpin.Flag("push.graphite-prefix", "Prefix of the Graphite paths of the metrics.").Default("").String()
+		pushInterval  = kingpin.Flag("push.interval", "Interval between collections in push modes.").Default("1m").Duration()
+		once          = kingpin.Flag("once", "Collect once, print the metrics to stdout and exit, non-zero if Consul was unreachable.").Default("false").Bool()
+		dumpCfg       = kingpin.Flag("dump-config", "Print the effective configuration merged from flags, environment variables and the configuration file as JSON, with secrets redacted, and exit.").Bool()
+		overview      = kingpin.Flag("web.overview", "Serve a read-only HTML overview of the last collection at /overview.").Default("false").Bool()
+		auditLog      = kingpin.Flag("web.audit-log", "Log every request to the metrics path with remote address, user agent, duration and requested collectors.").Default("false").Bool()
+		otlpEndpoint  = kingpin.Flag("tracing.otlp-endpoint", "Base URL of an OTLP/HTTP receiver, e.g. http://localhost:4318, to send traces of the collections to.").Default("").String()
+		collectEvery  = kingpin.Flag("collect.interval", "Collect in the background at this interval and serve the cached metrics, 0 collects at every scrape.").Default("0s").Duration()
+		minInterval   = kingpin.Flag("collect.min-interval", "Serve the metrics of the last collection to scrapes arriving less than this interval after it, 0 collects at every scrape. Ignored with collect.interval.").Default("0s").Duration()
+		collectTO     = kingpin.Flag("collect.timeout", "Deadline of a whole collection, after which the remaining collectors are abandoned, 0 means none.").Default("0s").Duration()
+		workers       = kingpin.Flag("collect.workers", "Maximum number of goroutines querying Consul concurrently, 0 means unbounded.").Default("32").Int()
+		dcStagger     = kingpin.Flag("collect.dc-stagger", "Spread the start of the datacenters' collections over this window, 0 queries all of them at once.").Default("0s").Duration()
+		dcSerial      = kingpin.Flag("collect.dc-serial", "Collect datacenters one after the other.").Default("false").Bool()
+		pluginsDir    = kingpin.Flag("plugins.dir", "Directory of executables run at every collection, whose metrics in the text format or as JSON are merged into the output.").Default("").String()
+		pluginsTO     = kingpin.Flag("plugins.timeout", "Timeout of a plugin run.").Default("10s").Duration()
+		dnsServices   = kingpin.Flag("dns.service", "Service, optionally prefixed with a tag, to resolve through the Consul DNS interface with the dns collector. May be repeated.").Strings()
+		dnsAddress    = kingpin.Flag("dns.address", "Address of the Consul DNS interface, port 8600 of the Consul host by default.").Default("").String()
+		dnsDomain     = kingpin.Flag("dns.domain", "DNS domain of Consul.").Default("consul").String()
+		metricsNS     = kingpin.Flag("metrics.namespace", "Namespace replacing the \"consul\" prefix of all exported metric names.").Default(exporter.Namespace).String()
+
+		opts   = exporter.ConsulOpts{}
+		rwOpts = remoteWriteOpts{}
 	)
-	serviceNodesHealthy = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "catalog_service_node_healthy"),
-		"Is this service healthy on this node?",
-		[]string{"service_id", "node", "service_name", "datacenter", "tags"}, nil,
+	kingpin.Flag("consul.server", "HTTP API address of a Consul server or agent. (prefix with https:// to connect over HTTPS)").Default("http://localhost:8500").StringVar(&opts.URI)
+	kingpin.Flag("consul.ca-file", "File path to a PEM-encoded certificate authority used to validate the authenticity of a server certificate.").Default("").StringVar(&opts.CAFile)
+	kingpin.Flag("consul.cert-file", "File path to a PEM-encoded certificate used with the private key to verify the exporter's authenticity.").Default("").StringVar(&opts.CertFile)
+	kingpin.Flag("consul.key-file", "File path to a PEM-encoded private key used with the certificate to verify the exporter's authenticity.").Default("").StringVar(&opts.KeyFile)
+	kingpin.Flag("consul.server-name", "When provided, this overrides the hostname for the TLS certificate. It can be used to ensure that the certificate name matches the hostname we declare.").Default("").StringVar(&opts.ServerName)
+	kingpin.Flag("consul.timeout", "Timeout on HTTP requests to consul.").Default("200ms").DurationVar(&opts.Timeout)
+	var (
+		nodesFilter    = kingpin.Flag("consul.nodes-filter", "Filter expression applied by Consul to the catalog nodes query.").Default("").String()
+		servicesFilter = kingpin.Flag("consul.services-filter", "Filter expression applied by Consul to the catalog services query.").Default("").String()
+		healthFilter   = kingpin.Flag("consul.health-filter", "Filter expression applied by Consul to the health state query.").Default("").String()
+		includeKinds   = kingpin.Flag("catalog.include-kind", "Only collect services of this kind (typical, connect-proxy, mesh-gateway, ...). Can be repeated.").Strings()
+		excludeKinds   = kingpin.Flag("catalog.exclude-kind", "Don't collect services of this kind (typical, connect-proxy, mesh-gateway, ...). Can be repeated.").Strings()
 	)
-	nodeChecks = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "health_node_status"),
-		"Status of health checks associated with a node.",
-		[]string{"check", "node", "status", "datacenter"}, nil,
+
+	// Query options.
+	kingpin.Flag("consul.allow_stale", "Allows any Consul server (non-leader) to service a read.").Default("true").BoolVar(&opts.AllowStale)
+	kingpin.Flag("consul.require_consistent", "Forces the read to be fully consistent.").Default("false").BoolVar(&opts.RequireConsistent)
+	var (
+		catalogConsistency = kingpin.Flag("consul.catalog-consistency", "Consistency mode of catalog reads (stale, default or consistent), overriding the global query options.").Enum(exporter.ConsistencyStale, exporter.ConsistencyDefault, exporter.ConsistencyConsistent)
+		healthConsistency  = kingpin.Flag("consul.health-consistency", "Consistency mode of health reads (stale, default or consistent), overriding the global query options.").Enum(exporter.ConsistencyStale, exporter.ConsistencyDefault, exporter.ConsistencyConsistent)
+		kvConsistency      = kingpin.Flag("consul.kv-consistency", "Consistency mode of KV reads (stale, default or consistent), overriding the global query options.").Enum(exporter.ConsistencyStale, exporter.ConsistencyDefault, exporter.ConsistencyConsistent)
 	)
-	serviceChecks = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "health_service_status"),
-		"Status of health checks associated with a service.",
-		[]string{"check", "node", "service_id", "service_name", "status", "datacenter", "tags"}, nil,
+
+	// Remote write.
+	kingpin.Flag("remote-write.url", "URL of a Prometheus remote-write endpoint to push the metrics to every push.interval.").Default("").StringVar(&rwOpts.url)
+	kingpin.Flag("remote-write.username", "Username for basic authentication against the remote-write endpoint.").Default("").StringVar(&rwOpts.username)
+	kingpin.Flag("remote-write.password", "Password for basic authentication against the remote-write endpoint.").Default("").StringVar(&rwOpts.password)
+	kingpin.Flag("remote-write.bearer-token-file", "File containing the bearer token for the remote-write endpoint.").Default("").StringVar(&rwOpts.bearerTokenFile)
+	kingpin.Flag("remote-write.ca-file", "File path to a PEM-encoded certificate authority used to validate the remote-write endpoint's certificate.").Default("").StringVar(&rwOpts.caFile)
+	kingpin.Flag("remote-write.cert-file", "File path to a PEM-encoded client certificate for the remote-write endpoint.").Default("").StringVar(&rwOpts.certFile)
+	kingpin.Flag("remote-write.key-file", "File path to a PEM-encoded private key for the remote-write endpoint.").Default("").StringVar(&rwOpts.keyFile)
+	kingpin.Flag("remote-write.server-name", "Overrides the hostname for the remote-write endpoint's TLS certificate.").Default("").StringVar(&rwOpts.serverName)
+	kingpin.Flag("remote-write.insecure-skip-verify", "Disable verification of the remote-write endpoint's TLS certificate.").Default("false").BoolVar(&rwOpts.insecureSkipVerify)
+	kingpin.Flag("remote-write.timeout", "Timeout of remote-write requests.").Default("30s").DurationVar(&rwOpts.timeout)
+
+	// Collectors.
+	collectors := map[string]*bool{}
+	for name, enabled := range exporter.Collectors() {
+		state := "Disable"
+		if !enabled {
+			state = "Enable"
+		}
+		collectors[name] = kingpin.Flag("collector."+name, state+" the "+name+" collector.").Default(fmt.Sprint(enabled)).Bool()
+	}
+
+	// Logging.
+	var (
+		logLevel  = kingpin.Flag("log.level", "Only log messages with the given severity or above. One of: [debug, info, warn, error]").Default("info").Enum("debug", "info", "warn", "error")
+		logFormat = kingpin.Flag("log.format", "Output format of log messages. One of: [logfmt, json]").Default(logFormatLogfmt).Enum(logFormatLogfmt, logFormatJSON)
 	)
-	keyValues = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "catalog_kv"),
-		"The values for selected keys in Consul's key/value catalog. Keys with non-numeric values are omitted.",
-		[]string{"key"}, nil,
+	// Commands.
+	kingpin.Command("serve", "Serve the metrics, the default.").Default()
+	var (
+		healthCmd   = kingpin.Command("healthcheck", "Exit 0 if the exporter listening on web.listen-address is healthy, 1 otherwise, e.g. for a Docker HEALTHCHECK.")
+		healthCmdTO = healthCmd.Flag("timeout", "Timeout of the health check.").Default("5s").Duration()
+		rulesCmd    = kingpin.Command("generate-rules", "Print Prometheus alerting rules for the metrics of the exporter, named according to metrics.namespace.")
+		dashCmd     = kingpin.Command("generate-dashboard", "Print a Grafana dashboard with panels for the metrics of the enabled collectors.")
+		checkCmd    = kingpin.Command("check-config", "Validate the configuration file and flags, including regexes, filter expressions and TLS files, and exit non-zero on errors.")
 	)
-	queryOptions = consul_api.QueryOptions{}
-)
-
-// Exporter collects Consul stats from the given server and exports them using
-// the prometheus metrics package.
-type Exporter struct {
-	client        *consul_api.Client
-	kvPrefix      string
-	kvFilter      *regexp.Regexp
-	healthSummary bool
-}
+	kingpin.Version(version.Print("consul_exporter"))
+	kingpin.HelpFlag.Short('h')
+	cmd := kingpin.Parse()
+	if cmd == healthCmd.FullCommand() {
+		if err := healthcheck(*listenAddress, *healthCmdTO); err != nil {
+			fmt.Fprintln(os.Stderr, "Unhealthy:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if cmd == rulesCmd.FullCommand() {
+		os.Stdout.Write(exporter.AlertingRules(*metricsNS))
+		return
+	}
 
-type consulOpts struct {
-	uri        string
-	caFile     string
-	certFile   string
-	keyFile    string
-	serverName string
-	timeout    time.Duration
-}
+	prometheus.MustRegister(version.NewCollector(strings.TrimSuffix(*metricsNS, "_") + "_exporter"))
 
-// NewExporter returns an initialized Exporter.
-func NewExporter(opts consulOpts, kvPrefix, kvFilter string, healthSummary bool) (*Exporter, error) {
-	uri := opts.uri
-	if !strings.Contains(uri, "://") {
-		uri = "http://" + uri
+	opts.Consistency = map[string]string{
+		exporter.EndpointCatalog: *catalogConsistency,
+		exporter.EndpointHealth:  *healthConsistency,
+		exporter.EndpointKV:      *kvConsistency,
 	}
-	u, err := url.Parse(uri)
+
+	l, err := newLogger(*logLevel, *logFormat)
 	if err != nil {
-		return nil, fmt.Errorf("invalid consul URL: %s", err)
+		fatal("Invalid log flags", "err", err)
 	}
-	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
-		return nil, fmt.Errorf("invalid consul URL: %s", uri)
+	logger = l
+	exporter.SetLogger(l)
+
+	if cmd != checkCmd.FullCommand() && cmd != dashCmd.FullCommand() {
+		logger.Info("Starting consul_exporter", "version", version.Info())
+		logger.Info("Build context", "build_context", version.BuildContext())
 	}
 
-	tlsConfig := consul_api.TLSConfig{
-		Address:  opts.serverName,
-		CAFile:   opts.caFile,
-		CertFile: opts.certFile,
-		KeyFile:  opts.keyFile,
-	}
-	config := consul_api.DefaultConfig()
-	config.Address = u.Host
-	config.Scheme = u.Scheme
-	config.TLSConfig = tlsConfig
-        config.HttpClient, err = consul_api.NewHttpClient(config.Transport, config.TLSConfig)
-	config.HttpClient.Timeout = opts.timeout
+	if *serverEnv != "" {
+		uri, err := serverFromEnv(opts.URI, *serverEnv)
+		if err != nil {
+			fatal("Can't resolve Consul server", "err", err)
+		}
+		logger.Info("Using Consul server from environment", "env", *serverEnv, "server", uri)
+		opts.URI = uri
+	}
 
-	client, err := consul_api.NewClient(config)
+	cfg, err := exporter.LoadConfig(*configFile)
 	if err != nil {
-		return nil, err
+		fatal("Can't load configuration file", "file", *configFile, "err", err)
+	}
+	cfg.KVFlag.JSON = *kvJSON
+	cfg.KVFlag.HCL = *kvHCL
+	cfg.KVFlag.YAML = *kvYAML
+	cfg.KVFlag.Allow = *kvAllow
+	cfg.KVDefaultDeny = cfg.KVDefaultDeny || *kvDefaultDeny
+	cfg.KVSensitive = append(cfg.KVSensitive, *kvSensitive...)
+	cfg.KVFlag.Info = *kvInfo
+	cfg.KVFlag.Bools = *kvBools
+	cfg.KVFlag.Flags = *kvFlags
+	cfg.KVFlag.ModifyIndex = *kvModifyIndex
+	cfg.KVFlag.Timestamps = *kvTimestamps
+	cfg.KVFlag.Depth = *kvDepth
+	cfg.KVFlag.SkipDirs = *kvSkipDirs
+	cfg.KVFlag.Decode = *kvDecode
+	cfg.KVFlag.CounterSuffix = *kvCounters
+
+	if *metricsNS != exporter.Namespace {
+		// Renaming happens before any configured relabeling, so that rules
+		// can be written against the final metric names.
+		rc, err := exporter.NamespaceRelabelConfig(*metricsNS)
+		if err != nil {
+			fatal("Error starting exporter", "err", err)
+		}
+		cfg.Relabel = append([]*exporter.RelabelConfig{rc}, cfg.Relabel...)
 	}
 
-	// Init our exporter.
-	return &Exporter{
-		client:        client,
-		kvPrefix:      kvPrefix,
-		kvFilter:      regexp.MustCompile(kvFilter),
-		healthSummary: healthSummary,
-	}, nil
-}
-
-// Describe describes all the metrics ever exported by the Consul exporter. It
-// implements prometheus.Collector.
-func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	ch <- up
-	ch <- clusterServers
-	ch <- clusterLeader
-	ch <- nodeCount
-	ch <- serviceCount
-	ch <- serviceNodesHealthy
-	ch <- nodeChecks
-	ch <- serviceChecks
-	ch <- keyValues
-	ch <- serviceTag
-}
-
-// Collect fetches the stats from configured Consul location and delivers them
-// as Prometheus metrics. It implements prometheus.Collector.
-func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	// How many peers are in the Consul cluster?
-	peers, err := e.client.Status().Peers()
+	effective, err := dumpConfig(kingpin.CommandLine, cfg)
 	if err != nil {
-		ch <- prometheus.MustNewConstMetric(
-			up, prometheus.GaugeValue, 0,
-		)
-		log.Errorf("Can't query consul: %v", err)
+		fatal("Can't render the effective configuration", "err", err)
+	}
+	if *dumpCfg {
+		os.Stdout.Write(append(effective, '\n'))
 		return
 	}
 
-	// We'll use peers to decide that we're up.
-	ch <- prometheus.MustNewConstMetric(
-		up, prometheus.GaugeValue, 1,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		clusterServers, prometheus.GaugeValue, float64(len(peers)),
-	)
-
-	leader, err := e.client.Status().Leader()
-	if err != nil {
-		log.Errorf("Can't query consul: %v", err)
+	options := []exporter.Option{
+		exporter.WithKVPrefix(*kvPrefix, *kvFilter),
+		exporter.WithMaxServices(*maxServices),
+		exporter.WithMeta(*nodeMeta, *serviceMeta),
+		exporter.WithChecksExclude(*checksExclude),
+		exporter.WithCheckLabel(*checkLabel),
+		exporter.WithTagBreakdown(*tagBreakdown),
+		exporter.WithFilters(*nodesFilter, *servicesFilter, *healthFilter),
+		exporter.WithServiceKinds(*includeKinds, *excludeKinds),
+		exporter.WithPlugins(*pluginsDir, *pluginsTO),
+		exporter.WithDNSProbe(*dnsAddress, *dnsDomain, *dnsServices),
+		exporter.WithMetricsFilter(*metricsIncl, *metricsExcl),
+		exporter.WithWorkers(*workers),
+		exporter.WithScrapeTimeout(*collectTO),
+		exporter.WithDatacenterStagger(*dcStagger, *dcSerial),
+		exporter.WithDatacentersTTL(*dcsTTL),
+		exporter.WithMaxRPS(*maxRPS),
+		exporter.WithShard(*shardIndex, *shardTotal),
+		exporter.WithConfig(cfg),
 	}
-	if len(leader) == 0 {
-		ch <- prometheus.MustNewConstMetric(
-			clusterLeader, prometheus.GaugeValue, 0,
-		)
-	} else {
-		ch <- prometheus.MustNewConstMetric(
-			clusterLeader, prometheus.GaugeValue, 1,
-		)
+	if *stateDuration {
+		options = append(options, exporter.WithCheckStateDuration())
 	}
-
-	datacenters, err := e.client.Catalog().Datacenters()
-	if err != nil {
-		c, _ := e.client.Agent().Self()
-		datacenters = []string{c["Config"]["Datacenter"].(string)}
+	if *checkType {
+		options = append(options, exporter.WithCheckType())
 	}
-
-	e.collectByDatacenter(ch, datacenters)
-
-	e.collectKeyValues(ch)
-}
-
-// collectHealthSummary collects health information about every node+service
-// combination. It will cause one lookup query per service.
-func (e *Exporter) collectByDatacenter(ch chan<- prometheus.Metric, datacenters []string) {
-	var wg sync.WaitGroup
-
-	for _, s := range datacenters {
-		wg.Add(1)
-		go func(s string) {
-			defer wg.Done()
-
-			var queryOptions = queryOptions
-			queryOptions.Datacenter = s
-			// How many nodes are registered?
-			nodes, _, err := e.client.Catalog().Nodes(&queryOptions)
-			if err != nil {
-				// FIXME: How should we handle a partial failure like this?
-			} else {
-				ch <- prometheus.MustNewConstMetric(
-					nodeCount, prometheus.GaugeValue, float64(len(nodes)), queryOptions.Datacenter,
-				)
-			}
-
-			// Query for the full list of services.
-			serviceNames, _, err := e.client.Catalog().Services(&queryOptions)
-			if err != nil {
-				// FIXME: How should we handle a partial failure like this?
-				return
-			}
-			ch <- prometheus.MustNewConstMetric(
-				serviceCount, prometheus.GaugeValue, float64(len(serviceNames)), queryOptions.Datacenter,
-			)
-
-			if e.healthSummary {
-				e.collectHealthSummary(ch, serviceNames, &queryOptions)
-			}
-
-			checks, _, err := e.client.Health().State("any", &queryOptions)
-			if err != nil {
-				log.Errorf("Failed to query service health: %v", err)
-				return
-			}
-
-			for _, hc := range checks {
-				var status float64
-
-				switch hc.Status {
-				case consul_api.HealthPassing:
-					status = 1
-				case consul_api.HealthWarning:
-					status = 2
-				case consul_api.HealthCritical:
-					status = 3
-				case consul_api.HealthMaint:
-					status = 0
-				}
-
-				if hc.ServiceID == "" {
-					ch <- prometheus.MustNewConstMetric(
-						nodeChecks, prometheus.GaugeValue, status, hc.CheckID, hc.Node, hc.Status, queryOptions.Datacenter,
-					)
-				} else {
-					ch <- prometheus.MustNewConstMetric(
-						serviceChecks, prometheus.GaugeValue, status, hc.CheckID, hc.Node, hc.ServiceID, hc.ServiceName, queryOptions.Datacenter, hc.Status, "," + strings.Join(hc.ServiceTags, ",") + ",",
-					)
-				}
-			}
-		}(s)
+	if *tagLabels {
+		options = append(options, exporter.WithServiceTagLabels())
 	}
-
-	wg.Wait()
-}
-
-// collectHealthSummary collects health information about every node+service
-// combination. It will cause one lookup query per service.
-func (e *Exporter) collectHealthSummary(ch chan<- prometheus.Metric, serviceNames map[string][]string, queryOptions *consul_api.QueryOptions) {
-	var wg sync.WaitGroup
-
-	for s := range serviceNames {
-		wg.Add(1)
-		go func(s string) {
-			defer wg.Done()
-			e.collectOneHealthSummary(ch, s, queryOptions)
-		}(s)
+	if *healthSummary {
+		options = append(options, exporter.WithHealthSummary())
 	}
-
-	wg.Wait()
-}
-
-func (e *Exporter) collectOneHealthSummary(ch chan<- prometheus.Metric, serviceName string, queryOptions *consul_api.QueryOptions) error {
-	log.Debugf("Fetching health summary for: %s", serviceName)
-
-	service, _, err := e.client.Health().Service(serviceName, "", false, queryOptions)
+	if *agentCache {
+		options = append(options, exporter.WithAgentCache(*cacheMaxAge))
+	}
+	if *kvWatch {
+		options = append(options, exporter.WithKVWatch())
+	}
+	if *kvTxn {
+		options = append(options, exporter.WithKVTxn())
+	}
+	if *catalogWatch {
+		options = append(options, exporter.WithCatalogWatch())
+	}
+	if *watchHealth {
+		options = append(options, exporter.WithServiceHealthWatch())
+	}
+	// Only serving replicas take part in the election. The other commands
+	// exit right away, and --once would only stand by while another replica
+	// holds the lock.
+	if *leaderKey != "" && cmd != checkCmd.FullCommand() && cmd != dashCmd.FullCommand() && !*once {
+		options = append(options, exporter.WithLeaderElection(*leaderKey))
+	}
+	if cmd == checkCmd.FullCommand() {
+		if err := checkTLSFiles(opts); err != nil {
+			fatal("Invalid TLS configuration", "err", err)
+		}
+		// The KV flags are only compiled by the exporter with kv.prefix.
+		if err := checkRegexes("kv.filter", *kvFilter); err != nil {
+			fatal("Invalid regex", "err", err)
+		}
+		if err := checkRegexes("kv.allow", *kvAllow...); err != nil {
+			fatal("Invalid regex", "err", err)
+		}
+	}
+	e, err := exporter.New(opts, options...)
 	if err != nil {
-		log.Errorf("Failed to query service health: %v", err)
-		return err
+		fatal("Error starting exporter", "err", err)
 	}
-
-	for _, entry := range service {
-		// We have a Node, a Service, and one or more Checks. Our
-		// service-node combo is passing if all checks have a `status`
-		// of "passing."
-		var status float64
-
-		switch entry.Checks.AggregatedStatus() {
-		case consul_api.HealthPassing:
-			status = 1
-		case consul_api.HealthWarning:
-			status = 2
-		case consul_api.HealthCritical:
-			status = 3
-		case consul_api.HealthMaint:
-			status = 0
+	var enabled []string
+	for name, on := range collectors {
+		if *on {
+			enabled = append(enabled, name)
 		}
-		ch <- prometheus.MustNewConstMetric(
-			serviceNodesHealthy, prometheus.GaugeValue, status, entry.Service.ID, entry.Node.Node, entry.Service.Service, queryOptions.Datacenter, ","+strings.Join(entry.Service.Tags, ",")+",",
-		)
 	}
-	return nil
-}
-
-func (e *Exporter) collectKeyValues(ch chan<- prometheus.Metric) {
-	if e.kvPrefix == "" {
+	if err := e.SetCollectors(enabled); err != nil {
+		fatal("Error starting exporter", "err", err)
+	}
+	switch cmd {
+	case checkCmd.FullCommand():
+		fmt.Println("Configuration is valid.")
+		return
+	case dashCmd.FullCommand():
+		os.Stdout.Write(e.Dashboard(*metricsNS))
 		return
 	}
-
-	kv := e.client.KV()
-	pairs, _, err := kv.List(e.kvPrefix, &queryOptions)
-	if err != nil {
-		log.Errorf("Error fetching key/values: %s", err)
+	if *otlpEndpoint != "" {
+		e.EnableTracing(*otlpEndpoint)
+	}
+	if *once {
+		if err := collectOnce(os.Stdout, e); err != nil {
+			fatal("Error collecting metrics", "err", err)
+		}
 		return
 	}
+	e.PublishDebugVars()
+
+	// The exporter isn't registered globally, the metrics handler collects it
+	// with the context of each request. Pushes don't have one.
+	var exporterGatherer prometheus.Gatherer
+	if *collectEvery > 0 {
+		logger.Info("Collecting in the background", "interval", *collectEvery)
+		exporterGatherer = e.CollectInBackground(*collectEvery)
+	} else if *minInterval > 0 {
+		logger.Info("Collecting at most once per interval", "interval", *minInterval)
+		exporterGatherer = e.CollectAtMostEvery(*minInterval)
+	} else {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(e)
+		exporterGatherer = registry
+	}
+	gatherer := prometheus.Gatherers{prometheus.DefaultGatherer, exporterGatherer}
 
-	for _, pair := range pairs {
-		if e.kvFilter.MatchString(pair.Key) {
-			val, err := strconv.ParseFloat(string(pair.Value), 64)
-			if err == nil {
-				ch <- prometheus.MustNewConstMetric(
-					keyValues, prometheus.GaugeValue, val, pair.Key,
-				)
-			}
+	if rwOpts.url != "" {
+		rw, err := newRemoteWriter(rwOpts, gatherer)
+		if err != nil {
+			fatal("Error starting exporter", "err", err)
 		}
+		logger.Info("Sending metrics to remote-write endpoint", "url", rwOpts.url, "interval", *pushInterval)
+		go pushEvery(*pushInterval, rwOpts.url, rw.write)
 	}
-}
-
-func init() {
-	prometheus.MustRegister(version.NewCollector("consul_exporter"))
-}
-
-func main() {
-	var (
-		listenAddress = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9107").String()
-		metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
-		healthSummary = kingpin.Flag("consul.health-summary", "Generate a health summary for each service instance. Needs n+1 queries to collect all information.").Default("true").Bool()
-		kvPrefix      = kingpin.Flag("kv.prefix", "Prefix from which to expose key/value pairs.").Default("").String()
-		kvFilter      = kingpin.Flag("kv.filter", "Regex that determines which keys to expose.").Default(".*").String()
-
-		opts = consulOpts{}
-	)
-	kingpin.Flag("consul.server", "HTTP API address of a Consul server or agent. (prefix with https:// to connect over HTTPS)").Default("http://localhost:8500").StringVar(&opts.uri)
-	kingpin.Flag("consul.ca-file", "File path to a PEM-encoded certificate authority used to validate the authenticity of a server certificate.").Default("").StringVar(&opts.caFile)
-	kingpin.Flag("consul.cert-file", "File path to a PEM-encoded certificate used with the private key to verify the exporter's authenticity.").Default("").StringVar(&opts.certFile)
-	kingpin.Flag("consul.key-file", "File path to a PEM-encoded private key used with the certificate to verify the exporter's authenticity.").Default("").StringVar(&opts.keyFile)
-	kingpin.Flag("consul.server-name", "When provided, this overrides the hostname for the TLS certificate. It can be used to ensure that the certificate name matches the hostname we declare.").Default("").StringVar(&opts.serverName)
-	kingpin.Flag("consul.timeout", "Timeout on HTTP requests to consul.").Default("200ms").DurationVar(&opts.timeout)
-
-	// Query options.
-	kingpin.Flag("consul.allow_stale", "Allows any Consul server (non-leader) to service a read.").Default("true").BoolVar(&queryOptions.AllowStale)
-	kingpin.Flag("consul.require_consistent", "Forces the read to be fully consistent.").Default("false").BoolVar(&queryOptions.RequireConsistent)
-
-	log.AddFlags(kingpin.CommandLine)
-	kingpin.Version(version.Print("consul_exporter"))
-	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
-
-	log.Infoln("Starting consul_exporter", version.Info())
-	log.Infoln("Build context", version.BuildContext())
-
-	exporter, err := NewExporter(opts, *kvPrefix, *kvFilter, *healthSummary)
-	if err != nil {
-		log.Fatalln(err)
+	if *pushGateway != "" {
+		pg, err := newPushgateway(*pushGateway, *pushJob, *pushGrouping, *pushTimeout, gatherer)
+		if err != nil {
+			fatal("Error starting exporter", "err", err)
+		}
+		logger.Info("Pushing metrics to Pushgateway", "url", pg.url, "interval", *pushInterval)
+		go pushEvery(*pushInterval, pg.url, pg.push)
 	}
-	prometheus.MustRegister(exporter)
-
-	queryOptionsJson, err := json.Marshal(queryOptions)
-	if err != nil {
-		log.Fatalln(err)
+	if *graphiteAddr != "" {
+		gr := &graphite{address: *graphiteAddr, prefix: *graphitePfx, timeout: *pushTimeout, gatherer: gatherer}
+		logger.Info("Sending metrics to Graphite", "address", gr.address, "interval", *pushInterval)
+		go pushEvery(*pushInterval, gr.address, gr.push)
+	}
+	if *pushTextfile != "" {
+		logger.Info("Writing metrics to textfile", "file", *pushTextfile, "interval", *pushInterval)
+		go pushEvery(*pushInterval, *pushTextfile, func() error {
+			return writeTextfile(*pushTextfile, gatherer)
+		})
 	}
 
-	http.Handle(*metricsPath, prometheus.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-             <head><title>Consul Exporter</title></head>
-             <body>
-             <h1>Consul Exporter</h1>
-             <p><a href='` + *metricsPath + `'>Metrics</a></p>
-             <h2>Options</h2>
-             <pre>` + string(queryOptionsJson) + `</pre>
-             </dl>
-             <h2>Build</h2>
-             <pre>` + version.Info() + ` ` + version.BuildContext() + `</pre>
-             </body>
-             </html>`))
-	})
+	var middleware func(http.Handler) http.Handler
+	if *auditLog {
+		middleware = auditHandler
+	}
+	http.Handle("/", exporter.Handler(exporter.HandlerConfig{
+		Exporter:          e,
+		MetricsPath:       *metricsPath,
+		MetricsMiddleware: middleware,
+		Namespace:         *metricsNS,
+		Overview:          *overview,
+		Config:            effective,
+	}))
+
+	if *grpcAddress != "" {
+		logger.Info("Serving gRPC health checks", "address", *grpcAddress)
+		go func() {
+			if err := serveGRPCHealth(*grpcAddress, e); err != nil {
+				fatal("Error starting gRPC server", "err", err)
+			}
+		}()
+	}
 
-	log.Infoln("Listening on", *listenAddress)
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	logger.Info("Listening", "address", *listenAddress)
+	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
+		fatal("Error starting HTTP server", "err", err)
+	}
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/consul_exporter/pkg/exporter"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// redactedValue replaces secrets in the effective configuration.
+const redactedValue = "[redacted]"
+
+// secretFlags are the flags holding secrets rather than paths to them.
+var secretFlags = map[string]bool{
+	"remote-write.password": true,
+}
+
+// consulEnvVars are the environment variables read by the Consul API client,
+// and whether they hold secrets.
+var consulEnvVars = map[string]bool{
+	"CONSUL_HTTP_ADDR":       false,
+	"CONSUL_HTTP_TOKEN":      true,
+	"CONSUL_HTTP_TOKEN_FILE": false,
+	"CONSUL_HTTP_AUTH":       true,
+	"CONSUL_HTTP_SSL":        false,
+	"CONSUL_HTTP_SSL_VERIFY": false,
+	"CONSUL_CACERT":          false,
+	"CONSUL_CAPATH":          false,
+	"CONSUL_CLIENT_CERT":     false,
+	"CONSUL_CLIENT_KEY":      false,
+	"CONSUL_TLS_SERVER_NAME": false,
+}
+
+// effectiveConfig is the configuration the exporter runs with, after flags,
+// environment variables and the configuration file were merged.
+type effectiveConfig struct {
+	Flags       map[string]string `json:"flags"`
+	Environment map[string]string `json:"environment"`
+	Config      *exporter.Config  `json:"config"`
+}
+
+// dumpConfig returns the effective configuration as JSON with secrets
+// redacted. Flag values are read after the exporter adjusted them, e.g.
+// consul.server after consul.server-from-env.
+func dumpConfig(app *kingpin.Application, cfg *exporter.Config) ([]byte, error) {
+	ec := effectiveConfig{Flags: map[string]string{}, Environment: map[string]string{}, Config: cfg}
+	for _, f := range app.Model().Flags {
+		if f.Name == "help" || f.Name == "version" {
+			continue
+		}
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = redactedValue
+		}
+		ec.Flags[f.Name] = redactURL(value)
+	}
+	names := make([]string, 0, len(consulEnvVars))
+	for name := range consulEnvVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if consulEnvVars[name] {
+			value = redactedValue
+		}
+		ec.Environment[name] = redactURL(value)
+	}
+	return json.MarshalIndent(ec, "", "  ")
+}
+
+// redactURL masks the password of a URL with credentials, returning other
+// values unchanged.
+func redactURL(value string) string {
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	return u.Redacted()
+}
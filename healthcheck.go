@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/prometheus/consul_exporter/pkg/exporter"
+)
+
+// healthcheck requests /-/healthy of the exporter listening on
+// listenAddress. It returns an error unless the exporter responds with 200.
+func healthcheck(listenAddress string, timeout time.Duration) error {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return err
+	}
+	// Listening on all interfaces includes the loopback one.
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + "/-/healthy")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// serveGRPCHealth serves the grpc.health.v1 Health service of the exporter
+// on address.
+func serveGRPCHealth(address string, e *exporter.Exporter) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer()
+	e.RegisterHealthServer(s)
+	return s.Serve(l)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthcheck(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy || r.URL.Path != "/-/healthy" {
+			http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	// Check through the unspecified address the exporter would listen on.
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+	if err := healthcheck(":"+port, time.Second); err != nil {
+		t.Errorf("expected healthy exporter, got %v", err)
+	}
+	healthy = false
+	if err := healthcheck("0.0.0.0:"+port, time.Second); err == nil {
+		t.Errorf("expected error for unhealthy exporter")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+const (
+	logFormatLogfmt = "logfmt"
+	logFormatJSON   = "json"
+)
+
+// logger is the structured logger of the exporter, replaced in main according
+// to the log flags.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// newLogger returns a logger writing records of at least the given level
+// (debug, info, warn or error) to stderr in the given format.
+func newLogger(level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+
+	switch format {
+	case logFormatLogfmt:
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case logFormatJSON:
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...interface{}) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestNewLogger(t *testing.T) {
+	for _, test := range []struct {
+		level, format string
+		ok            bool
+	}{
+		{level: "info", format: logFormatLogfmt, ok: true},
+		{level: "debug", format: logFormatJSON, ok: true},
+		{level: "verbose", format: logFormatLogfmt},
+		{level: "info", format: "xml"},
+	} {
+		_, err := newLogger(test.level, test.format)
+		if (err == nil) != test.ok {
+			t.Errorf("newLogger(%q, %q): unexpected error %v", test.level, test.format, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package exporter
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// consulURL parses the address of Consul, a URL or a host with an optional
+// port. IPv6 literals must be enclosed in brackets when followed by a port,
+// e.g. [::1]:8500. Bare literals like ::1 are bracketed, and zones like
+// [fe80::1%eth0] don't need to be escaped.
+func consulURL(uri string) (*url.URL, error) {
+	if !strings.Contains(uri, "://") {
+		uri = "http://" + uri
+	}
+	u, err := url.Parse(escapeZone(uri))
+	if err != nil {
+		return nil, fmt.Errorf("invalid consul URL: %s", err)
+	}
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid consul URL: %s", uri)
+	}
+	if !strings.HasPrefix(u.Host, "[") && strings.Count(u.Host, ":") > 1 {
+		if ip := net.ParseIP(u.Host); ip == nil {
+			return nil, fmt.Errorf("invalid consul URL: %s: IPv6 addresses with a port must be enclosed in brackets, e.g. [::1]:8500", uri)
+		}
+		u.Host = "[" + u.Host + "]"
+	}
+	return u, nil
+}
+
+// escapeZone escapes the percent sign separating the zone of a bracketed IPv6
+// literal in a URL, which url.Parse requires as %25.
+func escapeZone(uri string) string {
+	start := strings.Index(uri, "[")
+	end := strings.Index(uri, "]")
+	if start < 0 || end < start {
+		return uri
+	}
+	i := strings.Index(uri[start:end], "%")
+	if i < 0 || strings.HasPrefix(uri[start+i:], "%25") {
+		return uri
+	}
+	return uri[:start+i] + "%25" + uri[start+i+1:]
+}
@@ -0,0 +1,31 @@
+package exporter
+
+import "testing"
+
+func TestConsulURL(t *testing.T) {
+	for uri, expected := range map[string]string{
+		"localhost:8500":             "http://localhost:8500",
+		"https://consul:8501":        "https://consul:8501",
+		"[::1]:8500":                 "http://[::1]:8500",
+		"http://[::1]:8500":          "http://[::1]:8500",
+		"::1":                        "http://[::1]",
+		"2001:db8::1":                "http://[2001:db8::1]",
+		"[fe80::1%eth0]:8500":        "http://[fe80::1%25eth0]:8500",
+		"http://[fe80::1%25eth0]:80": "http://[fe80::1%25eth0]:80",
+	} {
+		u, err := consulURL(uri)
+		if err != nil {
+			t.Errorf("%s: %s", uri, err)
+			continue
+		}
+		if got := u.String(); got != expected {
+			t.Errorf("%s: expected %s, got %s", uri, expected, got)
+		}
+	}
+
+	for _, uri := range []string{"::1:8500:x", "ftp://consul", "http://"} {
+		if _, err := consulURL(uri); err == nil {
+			t.Errorf("%s: expected an error", uri)
+		}
+	}
+}
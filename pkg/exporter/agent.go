@@ -0,0 +1,48 @@
+package exporter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector(collectorAgent, agentCollector{}, false)
+}
+
+// agentCollector collects information about the Consul agent the exporter
+// queries, its runtime, the LAN members it knows and whether its services and
+// checks are in sync with the catalog.
+type agentCollector struct{}
+
+func (agentCollector) collect(s *scrape, ch chan<- prometheus.Metric) {
+	e := s.e
+	defer e.observe(ch, collectorAgent, "", time.Now())
+
+	var self map[string]map[string]interface{}
+	if err := e.rawQuery(s.ctx, "/v1/agent/self", &self); err != nil {
+		e.queryError("/v1/agent/self", "", err)
+		return
+	}
+	config := self["Config"]
+	ch <- prometheus.MustNewConstMetric(
+		agentInfo, prometheus.GaugeValue, 1,
+		selfValue(config["NodeName"]), selfValue(config["Datacenter"]), selfValue(config["Version"]), selfValue(config["Server"]),
+	)
+	node := selfValue(config["NodeName"])
+	e.collectRuntime(s.ctx, ch, self, node)
+	e.collectSegments(s.ctx, ch)
+	if node != "" {
+		e.collectSync(s.ctx, ch, node)
+	}
+}
+
+// selfValue formats a value of the agent's self-description, which is
+// missing on some Consul versions.
+func selfValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,47 @@
+package exporter
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// collectRuntime collects the goroutines of the agent process from the Stats
+// section of its self-description, and its allocated memory and GC pauses
+// from the runtime gauges of its in-memory metrics, which are kept without
+// configuring telemetry.
+func (e *Exporter) collectRuntime(ctx context.Context, ch chan<- prometheus.Metric, self map[string]map[string]interface{}, node string) {
+	if runtime, ok := self["Stats"]["runtime"].(map[string]interface{}); ok {
+		if goroutines, err := strconv.ParseFloat(selfValue(runtime["goroutines"]), 64); err == nil {
+			ch <- prometheus.MustNewConstMetric(agentGoroutines, prometheus.GaugeValue, goroutines, node)
+		}
+	}
+
+	metrics := &consul_api.MetricsInfo{}
+	if err := e.rawQuery(ctx, "/v1/agent/metrics", metrics); err != nil {
+		e.queryError("/v1/agent/metrics", "", err)
+		return
+	}
+	if v, ok := runtimeGauge(metrics, "alloc_bytes"); ok {
+		ch <- prometheus.MustNewConstMetric(agentAllocBytes, prometheus.GaugeValue, v, node)
+	}
+	if v, ok := runtimeGauge(metrics, "total_gc_pause_ns"); ok {
+		ch <- prometheus.MustNewConstMetric(agentGCPause, prometheus.CounterValue, v/1e9, node)
+	}
+}
+
+// runtimeGauge returns the value of a runtime gauge of the agent's metrics.
+// Gauge names are prefixed with the configurable telemetry prefix, consul by
+// default.
+func runtimeGauge(metrics *consul_api.MetricsInfo, name string) (float64, bool) {
+	for _, g := range metrics.Gauges {
+		if strings.HasSuffix(g.Name, ".runtime."+name) {
+			return float64(g.Value), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,20 @@
+package exporter
+
+import (
+	"testing"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+func TestRuntimeGauge(t *testing.T) {
+	metrics := &consul_api.MetricsInfo{Gauges: []consul_api.GaugeValue{
+		{Name: "consul.runtime.num_goroutines", Value: 120},
+		{Name: "custom.runtime.alloc_bytes", Value: 4096},
+	}}
+	if v, ok := runtimeGauge(metrics, "alloc_bytes"); !ok || v != 4096 {
+		t.Errorf("expected alloc_bytes 4096, got %v, %v", v, ok)
+	}
+	if _, ok := runtimeGauge(metrics, "total_gc_pause_ns"); ok {
+		t.Error("expected missing gauge not to be found")
+	}
+}
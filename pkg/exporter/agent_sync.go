@@ -0,0 +1,105 @@
+package exporter
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// collectSync compares the services and checks registered with the agent to
+// those of its node in the catalog.
+func (e *Exporter) collectSync(ctx context.Context, ch chan<- prometheus.Metric, node string) {
+	agent := e.client.Agent()
+	services, err := agent.Services()
+	if err != nil {
+		e.queryError("/v1/agent/services", "", err)
+		return
+	}
+	checks, err := agent.Checks()
+	if err != nil {
+		e.queryError("/v1/agent/checks", "", err)
+		return
+	}
+
+	opts, cancel := e.queryOptions(ctx, "", EndpointCatalog)
+	defer cancel()
+	catalogNode, _, err := e.client.Catalog().Node(node, opts)
+	if err != nil {
+		e.collectorError(collectorAgent, "/v1/catalog/node", "", err)
+		return
+	}
+	var catalogServices map[string]*consul_api.AgentService
+	if catalogNode != nil {
+		catalogServices = catalogNode.Services
+	}
+	catalogChecks, _, err := e.client.Health().Node(node, opts)
+	if err != nil {
+		e.collectorError(collectorAgent, "/v1/health/node", "", err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		agentOutOfSync, prometheus.GaugeValue, float64(servicesOutOfSync(services, catalogServices)), node, "service",
+	)
+	ch <- prometheus.MustNewConstMetric(
+		agentOutOfSync, prometheus.GaugeValue, float64(checksOutOfSync(checks, catalogChecks)), node, "check",
+	)
+}
+
+// servicesOutOfSync returns the number of services missing on either side or
+// registered differently. The consul service of servers is only in the
+// catalog.
+func servicesOutOfSync(agent, catalog map[string]*consul_api.AgentService) int {
+	n := 0
+	for id, s := range agent {
+		c, ok := catalog[id]
+		if !ok || c.Service != s.Service || c.Port != s.Port || c.Address != s.Address ||
+			(!s.EnableTagOverride && !equalTags(c.Tags, s.Tags)) {
+			n++
+		}
+	}
+	for id := range catalog {
+		if _, ok := agent[id]; !ok && id != "consul" {
+			n++
+		}
+	}
+	return n
+}
+
+// checksOutOfSync returns the number of checks missing on either side or
+// with a different status. Outputs are synced lazily and not compared. The
+// serfHealth check is maintained by the servers and only in the catalog.
+func checksOutOfSync(agent map[string]*consul_api.AgentCheck, catalog consul_api.HealthChecks) int {
+	n := 0
+	seen := make(map[string]bool, len(catalog))
+	for _, c := range catalog {
+		if c.CheckID == "serfHealth" {
+			continue
+		}
+		seen[c.CheckID] = true
+		if a, ok := agent[c.CheckID]; !ok || a.Status != c.Status || a.ServiceID != c.ServiceID {
+			n++
+		}
+	}
+	for id := range agent {
+		if !seen[id] {
+			n++
+		}
+	}
+	return n
+}
+
+// equalTags reports whether two tag lists hold the same tags.
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, "\xff") == strings.Join(b, "\xff")
+}
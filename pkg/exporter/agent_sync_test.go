@@ -0,0 +1,39 @@
+package exporter
+
+import (
+	"testing"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+func TestOutOfSync(t *testing.T) {
+	agentServices := map[string]*consul_api.AgentService{
+		"web1": {ID: "web1", Service: "web", Port: 80, Tags: []string{"a", "b"}},
+		"db1":  {ID: "db1", Service: "db", Port: 5432},
+	}
+	catalogServices := map[string]*consul_api.AgentService{
+		"consul":   {ID: "consul", Service: "consul", Port: 8300},
+		"web1":     {ID: "web1", Service: "web", Port: 80, Tags: []string{"b", "a"}},
+		"db1":      {ID: "db1", Service: "db", Port: 5433},
+		"phantom1": {ID: "phantom1", Service: "phantom"},
+	}
+	// db1 differs in its port, phantom1 is only in the catalog.
+	if n := servicesOutOfSync(agentServices, catalogServices); n != 2 {
+		t.Errorf("expected 2 services out of sync, got %d", n)
+	}
+
+	agentChecks := map[string]*consul_api.AgentCheck{
+		"service:web1": {CheckID: "service:web1", ServiceID: "web1", Status: consul_api.HealthPassing},
+		"service:db1":  {CheckID: "service:db1", ServiceID: "db1", Status: consul_api.HealthCritical},
+		"disk":         {CheckID: "disk", Status: consul_api.HealthPassing},
+	}
+	catalogChecks := consul_api.HealthChecks{
+		{CheckID: "serfHealth", Status: consul_api.HealthPassing},
+		{CheckID: "service:web1", ServiceID: "web1", Status: consul_api.HealthPassing, Output: "stale"},
+		{CheckID: "service:db1", ServiceID: "db1", Status: consul_api.HealthPassing},
+	}
+	// db1's status differs, disk is only registered with the agent.
+	if n := checksOutOfSync(agentChecks, catalogChecks); n != 2 {
+		t.Errorf("expected 2 checks out of sync, got %d", n)
+	}
+}
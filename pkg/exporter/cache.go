@@ -0,0 +1,89 @@
+package exporter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricsCache holds the metric families gathered by the last background
+// collection. It implements prometheus.Gatherer.
+type metricsCache struct {
+	mtx sync.RWMutex
+	mfs []*dto.MetricFamily
+
+	// gather refreshes the cache on demand if set, when the metrics are
+	// older than minInterval.
+	gather      func() ([]*dto.MetricFamily, error)
+	minInterval time.Duration
+	gathered    time.Time
+}
+
+func (c *metricsCache) Gather() ([]*dto.MetricFamily, error) {
+	if c.gather == nil {
+		c.mtx.RLock()
+		defer c.mtx.RUnlock()
+		return c.mfs, nil
+	}
+	// Concurrent scrapes wait for the same collection.
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if !c.gathered.IsZero() && time.Since(c.gathered) < c.minInterval {
+		return c.mfs, nil
+	}
+	mfs, err := c.gather()
+	c.mfs, c.gathered = mfs, time.Now()
+	return mfs, err
+}
+
+func (c *metricsCache) store(mfs []*dto.MetricFamily) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.mfs = mfs
+}
+
+// CollectInBackground collects the exporter right away and then every
+// interval, and returns a gatherer of the metrics of the last collection.
+// Afterwards the metrics handler serves them from that cache instead of
+// querying Consul, unless the request selects collectors or datacenters. This
+// decouples scrapes from slow collections and keeps the load on Consul
+// independent of the number of scrapers. It must be called before serving
+// requests.
+func (e *Exporter) CollectInBackground(interval time.Duration) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	e.cache = &metricsCache{}
+
+	collect := func() {
+		mfs, err := registry.Gather()
+		if err != nil {
+			// Gather returns what it could collect along with the error.
+			logger.Error("Error during background collection", "err", err)
+		}
+		e.cache.store(mfs)
+	}
+	collect()
+	go func() {
+		for range time.Tick(interval) {
+			collect()
+		}
+	}()
+	return e.cache
+}
+
+// CollectAtMostEvery returns a gatherer collecting the exporter when gathered,
+// unless the metrics of the last collection are younger than interval, in
+// which case they are served again. Like with CollectInBackground, the
+// metrics handler serves them unless the request selects collectors or
+// datacenters, so that frequent scrapes by several Prometheus servers don't
+// each query Consul. Collections don't use the context of the requests. It
+// must be called before serving requests.
+func (e *Exporter) CollectAtMostEvery(interval time.Duration) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	e.cache = &metricsCache{gather: registry.Gather, minInterval: interval}
+	return e.cache
+}
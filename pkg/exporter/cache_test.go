@@ -0,0 +1,54 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollectInBackground(t *testing.T) {
+	e, err := New(ConsulOpts{URI: "localhost:1", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mfs, err := e.CollectInBackground(time.Hour).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "consul_up" {
+			if v := mf.Metric[0].GetGauge().GetValue(); v != 0 {
+				t.Errorf("expected consul_up 0 for unreachable Consul, got %v", v)
+			}
+			return
+		}
+	}
+	t.Errorf("expected consul_up in the cached metrics, got %v", mfs)
+}
+
+func TestMetricsCacheMinInterval(t *testing.T) {
+	var gathered int
+	c := &metricsCache{
+		gather: func() ([]*dto.MetricFamily, error) {
+			gathered++
+			return nil, nil
+		},
+		minInterval: time.Hour,
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Gather(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gathered != 1 {
+		t.Errorf("expected 1 collection within the interval, got %d", gathered)
+	}
+
+	c.gathered = time.Now().Add(-2 * time.Hour)
+	c.Gather()
+	if gathered != 2 {
+		t.Errorf("expected a new collection after the interval, got %d", gathered)
+	}
+}
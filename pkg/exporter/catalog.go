@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+func init() {
+	registerCollector(collectorCatalog, catalogCollector{}, true)
+}
+
+// catalogCollector collects the number of nodes and services of each
+// datacenter.
+type catalogCollector struct{}
+
+func (catalogCollector) collect(s *scrape, ch chan<- prometheus.Metric) {
+	e := s.e
+	s.forEachDatacenter(func(dc string) {
+		ctx, span := e.tracer.start(s.ctx, "collect catalog", "consul.datacenter", dc)
+		defer span.finish(nil)
+		defer s.observe(ch, collectorCatalog, dc, time.Now())
+
+		catalogOptions, cancel := e.queryOptions(ctx, dc, EndpointCatalog)
+		defer cancel()
+		e.collectNodes(ch, catalogOptions)
+
+		services, err := s.catalogServices(dc)
+		if err != nil {
+			return
+		}
+		truncated := 0.0
+		if services.truncated {
+			truncated = 1
+		}
+		ch <- prometheus.MustNewConstMetric(
+			serviceCount, prometheus.GaugeValue, float64(services.count), dc,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			servicesTruncated, prometheus.GaugeValue, truncated, dc,
+		)
+	})
+}
+
+// collectNodes collects the registered nodes of a datacenter.
+func (e *Exporter) collectNodes(ch chan<- prometheus.Metric, queryOptions *consul_api.QueryOptions) {
+	// How many nodes are registered?
+	nodes, err := e.nodes(queryOptions)
+	if err != nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(
+		nodeCount, prometheus.GaugeValue, float64(len(nodes)), queryOptions.Datacenter,
+	)
+	e.snapshot.setNodes(queryOptions.Datacenter, nodes)
+	if e.nodeMeta != nil {
+		for _, node := range nodes {
+			if !e.shard.owns(node.Node) {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				e.nodeMeta, prometheus.GaugeValue, 1,
+				append([]string{node.Node, queryOptions.Datacenter}, metaLabelValues(e.nodeMetaKeys, node.Meta)...)...,
+			)
+		}
+	}
+}
+
+// nodes returns the nodes of the datacenter of the query options, from the
+// watch cache if enabled.
+func (e *Exporter) nodes(queryOptions *consul_api.QueryOptions) ([]*consul_api.Node, error) {
+	if e.watches != nil {
+		dw, err := e.watches.datacenter(queryOptions.Context(), queryOptions.Datacenter)
+		if err != nil {
+			e.queryError("/v1/catalog/nodes", queryOptions.Datacenter, err)
+			return nil, err
+		}
+		v, err := e.watched(dw.nodes)
+		nodes, _ := v.([]*consul_api.Node)
+		return nodes, err
+	}
+
+	nodes, meta, err := e.client.Catalog().Nodes(withFilter(queryOptions, e.nodesFilter))
+	if err != nil {
+		e.queryError("/v1/catalog/nodes", queryOptions.Datacenter, err)
+		return nil, err
+	}
+	e.indexes.record("/v1/catalog/nodes", queryOptions.Datacenter, meta.LastIndex)
+	return nodes, nil
+}
+
+// truncateServices returns the first max services of the catalog in
+// lexicographical order, so that truncation is stable across scrapes.
+func truncateServices(serviceNames map[string][]string, max int) map[string][]string {
+	names := make([]string, 0, len(serviceNames))
+	for name := range serviceNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	truncated := make(map[string][]string, max)
+	for _, name := range names[:max] {
+		truncated[name] = serviceNames[name]
+	}
+	return truncated
+}
@@ -0,0 +1,232 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// catalogWatches keeps the services, nodes and health checks of every
+// collected datacenter up to date with blocking queries. Scrapes read the
+// cached results instead of listing the whole catalog.
+type catalogWatches struct {
+	client *consul_api.Client
+	e      *Exporter
+	// perService watches the health of each service instead of the health
+	// state of the whole datacenter.
+	perService bool
+
+	mtx sync.Mutex
+	dcs map[string]*datacenterWatches
+}
+
+// datacenterWatches are the watches of a single datacenter.
+type datacenterWatches struct {
+	services *watcher
+	nodes    *watcher
+	checks   *watcher
+	// serviceHealth watches the health of each service if enabled, checks
+	// then only holds the node checks.
+	serviceHealth *serviceHealthWatches
+	// unchecked watches the instances of the services without any service
+	// check, which the health state doesn't know about. Per-service health
+	// watches already hold them.
+	unchecked *serviceHealthWatches
+	// cancel stops the watches.
+	cancel context.CancelFunc
+}
+
+func newCatalogWatches(client *consul_api.Client, e *Exporter, perService bool) *catalogWatches {
+	return &catalogWatches{client: client, e: e, perService: perService, dcs: map[string]*datacenterWatches{}}
+}
+
+// datacenter returns the watches of the datacenter, starting them on first
+// use, so that datacenters appearing later are watched as well. Only
+// datacenters known to the catalog are watched, the watches of datacenters
+// which left it are stopped.
+func (cw *catalogWatches) datacenter(ctx context.Context, dc string) (*datacenterWatches, error) {
+	known, err := cw.e.knownDatacenters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cw.mtx.Lock()
+	defer cw.mtx.Unlock()
+	for name, dw := range cw.dcs {
+		if _, unknown := unknownDatacenter([]string{name}, known); unknown {
+			dw.cancel()
+			delete(cw.dcs, name)
+		}
+	}
+	if dw, ok := cw.dcs[dc]; ok {
+		return dw, nil
+	}
+	if _, unknown := unknownDatacenter([]string{dc}, known); unknown {
+		return nil, fmt.Errorf("datacenter %q isn't known to the catalog", dc)
+	}
+
+	e, catalog, health := cw.e, cw.client.Catalog(), cw.client.Health()
+	watchCtx, cancel := context.WithCancel(context.Background())
+	catalogOptions, _ := e.baseQueryOptions(dc, EndpointCatalog)
+	catalogOptions = *catalogOptions.WithContext(watchCtx)
+	healthOptions, _ := e.baseQueryOptions(dc, EndpointHealth)
+	healthOptions = *healthOptions.WithContext(watchCtx)
+	dw := &datacenterWatches{
+		services: newWatcher("/v1/catalog/services", catalogOptions, func(opts *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+			return catalog.Services(withFilter(opts, e.servicesFilter))
+		}),
+		nodes: newWatcher("/v1/catalog/nodes", catalogOptions, func(opts *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+			return catalog.Nodes(withFilter(opts, e.nodesFilter))
+		}),
+		checks: newWatcher("/v1/health/state", healthOptions, func(opts *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+			return health.State("any", withFilter(opts, e.healthFilter))
+		}),
+		cancel: cancel,
+	}
+	if cw.perService {
+		dw.checks = newWatcher("/v1/health/state", healthOptions, func(opts *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+			return health.State("any", withFilter(opts, nodeChecksFilter))
+		})
+		dw.serviceHealth = newServiceHealthWatches(health, healthOptions)
+	} else {
+		dw.unchecked = newServiceHealthWatches(health, healthOptions)
+	}
+	go dw.services.run()
+	go dw.nodes.run()
+	go dw.checks.run()
+	cw.dcs[dc] = dw
+	return dw, nil
+}
+
+// watched returns the cached result of the watch, recording its index like
+// that of a regular query. Errors are reported as failed queries.
+func (e *Exporter) watched(w *watcher) (interface{}, error) {
+	v, err := w.get()
+	if err != nil {
+		e.queryError(w.endpoint, w.dc, err)
+		return nil, err
+	}
+	e.indexes.record(w.endpoint, w.dc, w.lastIndex())
+	return v, nil
+}
+
+// nodeChecksFilter selects the checks of nodes from the health state.
+const nodeChecksFilter = `ServiceID == ""`
+
+// serviceHealthWatches watch the health of the services of a datacenter with
+// a blocking query per service. Agents with use_streaming_backend serve these
+// from Consul's streaming backend, which pushes changes to the agent instead
+// of the servers sending the full result on every change. Other agents
+// answer them as regular blocking queries.
+type serviceHealthWatches struct {
+	health *consul_api.Health
+	opts   consul_api.QueryOptions
+
+	mtx      sync.Mutex
+	services map[string]*serviceHealthWatch
+}
+
+// serviceHealthWatch is the watch of a single service.
+type serviceHealthWatch struct {
+	watcher *watcher
+	cancel  context.CancelFunc
+}
+
+func newServiceHealthWatches(health *consul_api.Health, opts consul_api.QueryOptions) *serviceHealthWatches {
+	return &serviceHealthWatches{health: health, opts: opts, services: map[string]*serviceHealthWatch{}}
+}
+
+// checks returns the service checks of the given services, starting watches
+// for new services and stopping those of services which went away. Services
+// whose watch hasn't synced yet are left out until it has, unless none has.
+func (sw *serviceHealthWatches) checks(serviceNames map[string][]string) (consul_api.HealthChecks, uint64, error) {
+	sw.sync(serviceNames)
+	entries, index, err := sw.entries(nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	var checks consul_api.HealthChecks
+	for _, entry := range entries {
+		// Node checks are watched once per node, not per service.
+		for _, hc := range entry.Checks {
+			if hc.ServiceID != "" {
+				checks = append(checks, hc)
+			}
+		}
+	}
+	return checks, index, nil
+}
+
+// sync starts watches for new services and stops those of services which
+// went away.
+func (sw *serviceHealthWatches) sync(serviceNames map[string][]string) {
+	sw.mtx.Lock()
+	defer sw.mtx.Unlock()
+	for name, w := range sw.services {
+		if _, ok := serviceNames[name]; !ok {
+			w.cancel()
+			delete(sw.services, name)
+		}
+	}
+	for name := range serviceNames {
+		if _, ok := sw.services[name]; !ok {
+			sw.services[name] = sw.watch(name)
+		}
+	}
+}
+
+// entries returns the instances of the given watched services, or of all if
+// names is nil. Services whose watch hasn't synced yet are left out until it
+// has, unless none has.
+func (sw *serviceHealthWatches) entries(names []string) ([]*consul_api.ServiceEntry, uint64, error) {
+	sw.mtx.Lock()
+	defer sw.mtx.Unlock()
+	watches := make([]*serviceHealthWatch, 0, len(sw.services))
+	if names == nil {
+		for _, w := range sw.services {
+			watches = append(watches, w)
+		}
+	}
+	for _, name := range names {
+		if w, ok := sw.services[name]; ok {
+			watches = append(watches, w)
+		}
+	}
+
+	var (
+		entries []*consul_api.ServiceEntry
+		index   uint64
+		synced  int
+		err     error
+	)
+	for _, w := range watches {
+		v, werr := w.watcher.get()
+		if werr != nil {
+			err = werr
+			continue
+		}
+		synced++
+		if i := w.watcher.lastIndex(); i > index {
+			index = i
+		}
+		service, _ := v.([]*consul_api.ServiceEntry)
+		entries = append(entries, service...)
+	}
+	if synced == 0 && err != nil {
+		return nil, 0, err
+	}
+	return entries, index, nil
+}
+
+// watch starts the watch of a service.
+func (sw *serviceHealthWatches) watch(name string) *serviceHealthWatch {
+	ctx, cancel := context.WithCancel(sw.opts.Context())
+	opts := *sw.opts.WithContext(ctx)
+	w := newWatcher("/v1/health/service", opts, func(opts *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+		return sw.health.Service(name, "", false, opts)
+	}, "service", name)
+	go w.run()
+	return &serviceHealthWatch{watcher: w, cancel: cancel}
+}
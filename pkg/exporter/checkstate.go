@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"sync"
+	"time"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// checkKey identifies a health check within the exporter's memory.
+type checkKey struct {
+	dc, node, serviceID, checkID string
+}
+
+// checkState is the status of a health check and when it was entered.
+type checkState struct {
+	status string
+	since  time.Time
+}
+
+// checkStates tracks the status transitions of health checks across
+// collections, so that the time a check spent in its current status survives
+// restarts of Prometheus. Transitions before the exporter first saw a check
+// are unknown, the time is counted from then.
+type checkStates struct {
+	mtx    sync.Mutex
+	states map[checkKey]*checkState
+}
+
+func newCheckStates() *checkStates {
+	return &checkStates{states: map[checkKey]*checkState{}}
+}
+
+// deregisterCountdown returns the time left until the agent deregisters the
+// service instance of a check which has been critical for d, if the check
+// sets DeregisterCriticalServiceAfter. The agent reaps overdue instances
+// periodically, so the countdown stops at 0.
+func deregisterCountdown(hc *consul_api.HealthCheck, d time.Duration) (time.Duration, bool) {
+	after := hc.Definition.DeregisterCriticalServiceAfterDuration
+	if hc.Status != consul_api.HealthCritical || hc.ServiceID == "" || after <= 0 {
+		return 0, false
+	}
+	if d >= after {
+		return 0, true
+	}
+	return after - d, true
+}
+
+// update records the checks collected from a datacenter at now and returns
+// how long each has been in its current status, in the order of checks.
+// Checks of the datacenter which weren't collected are forgotten.
+func (c *checkStates) update(dc string, checks consul_api.HealthChecks, now time.Time) []time.Duration {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	seen := make(map[checkKey]bool, len(checks))
+	durations := make([]time.Duration, len(checks))
+	for i, hc := range checks {
+		key := checkKey{dc, hc.Node, hc.ServiceID, hc.CheckID}
+		seen[key] = true
+		st, ok := c.states[key]
+		if !ok || st.status != hc.Status {
+			st = &checkState{status: hc.Status, since: now}
+			c.states[key] = st
+		}
+		durations[i] = now.Sub(st.since)
+	}
+	for key := range c.states {
+		if key.dc == dc && !seen[key] {
+			delete(c.states, key)
+		}
+	}
+	return durations
+}
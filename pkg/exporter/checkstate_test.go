@@ -0,0 +1,49 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+func TestCheckStates(t *testing.T) {
+	c := newCheckStates()
+	start := time.Now()
+	web := &consul_api.HealthCheck{Node: "n1", CheckID: "service:web", ServiceID: "web", Status: consul_api.HealthPassing}
+	db := &consul_api.HealthCheck{Node: "n1", CheckID: "service:db", ServiceID: "db", Status: consul_api.HealthPassing}
+
+	c.update("dc1", consul_api.HealthChecks{web, db}, start)
+	if d := c.update("dc1", consul_api.HealthChecks{web, db}, start.Add(time.Minute)); d[0] != time.Minute || d[1] != time.Minute {
+		t.Errorf("expected both checks passing for a minute, got %v", d)
+	}
+
+	critical := *web
+	critical.Status = consul_api.HealthCritical
+	if d := c.update("dc1", consul_api.HealthChecks{&critical}, start.Add(2*time.Minute)); d[0] != 0 {
+		t.Errorf("expected the transition to reset the duration, got %v", d)
+	}
+	if _, ok := c.states[checkKey{"dc1", "n1", "db", "service:db"}]; ok {
+		t.Error("expected the check missing from the collection to be forgotten")
+	}
+}
+
+func TestDeregisterCountdown(t *testing.T) {
+	hc := &consul_api.HealthCheck{ServiceID: "web", Status: consul_api.HealthCritical}
+	hc.Definition.DeregisterCriticalServiceAfterDuration = 10 * time.Minute
+
+	for d, want := range map[time.Duration]time.Duration{
+		0:                10 * time.Minute,
+		4 * time.Minute:  6 * time.Minute,
+		15 * time.Minute: 0,
+	} {
+		if got, ok := deregisterCountdown(hc, d); !ok || got != want {
+			t.Errorf("critical for %s: expected %s, got %s, %v", d, want, got, ok)
+		}
+	}
+
+	hc.Status = consul_api.HealthPassing
+	if _, ok := deregisterCountdown(hc, 0); ok {
+		t.Error("expected no countdown for a passing check")
+	}
+}
@@ -0,0 +1,255 @@
+package exporter
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector collects the metrics of one data source of a Consul cluster.
+// Collectors register themselves by name in an init function, can be
+// enabled individually and are run concurrently by Collect.
+type collector interface {
+	// collect sends the metrics of the scrape to ch. Failed queries are
+	// reported with Exporter.queryError.
+	collect(s *scrape, ch chan<- prometheus.Metric)
+}
+
+type registeredCollector struct {
+	collector
+	enabledByDefault bool
+}
+
+var collectorRegistry = map[string]registeredCollector{}
+
+// registerCollector registers a collector under name.
+func registerCollector(name string, c collector, enabledByDefault bool) {
+	collectorRegistry[name] = registeredCollector{c, enabledByDefault}
+}
+
+// Collectors returns the names of all collectors and whether they are enabled
+// by default.
+func Collectors() map[string]bool {
+	collectors := make(map[string]bool, len(collectorRegistry))
+	for name, rc := range collectorRegistry {
+		collectors[name] = rc.enabledByDefault
+	}
+	return collectors
+}
+
+// collectorNames returns the names of all collectors in lexicographical
+// order.
+func collectorNames() []string {
+	names := make([]string, 0, len(collectorRegistry))
+	for name := range collectorRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scrape is the state of a single collection shared by its collectors.
+type scrape struct {
+	ctx   context.Context
+	e     *Exporter
+	peers []string
+	// peersErr is the error of the peers query, the collectors run
+	// regardless.
+	peersErr error
+
+	dcOnce sync.Once
+	dcs    []string
+
+	mtx      sync.Mutex
+	services map[string]*catalogServices
+	// passes span the runs of all collectors of each datacenter.
+	passes map[string]*datacenterPass
+}
+
+// datacenterPass is the wall-clock span of the collection of a datacenter.
+type datacenterPass struct {
+	start, end time.Time
+}
+
+func newScrape(ctx context.Context, e *Exporter, peers []string) *scrape {
+	return &scrape{ctx: ctx, e: e, peers: peers, services: map[string]*catalogServices{}, passes: map[string]*datacenterPass{}}
+}
+
+// datacenters returns the datacenters to collect, by default all datacenters
+// known to the catalog. The first call starts their status records.
+func (s *scrape) datacenters() []string {
+	s.dcOnce.Do(func() {
+		e := s.e
+		s.dcs = e.datacenterNames
+		if len(s.dcs) == 0 {
+			s.dcs = s.catalogDatacenters()
+			e.stats.setDatacenters(s.dcs)
+		}
+		for _, dc := range s.dcs {
+			e.stats.startDatacenter(dc)
+		}
+	})
+	return s.dcs
+}
+
+// finish ends the status records of the collected datacenters, if any.
+func (s *scrape) finish() {
+	s.dcOnce.Do(func() {})
+	for _, dc := range s.dcs {
+		s.e.stats.finishDatacenter(dc, s.e.indexes.highest(dc))
+	}
+}
+
+// observe records the duration of the run of a collector for a datacenter,
+// extending the datacenter's pass to cover it.
+func (s *scrape) observe(ch chan<- prometheus.Metric, collector, dc string, start time.Time) {
+	s.e.observe(ch, collector, dc, start)
+	end := time.Now()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	p, ok := s.passes[dc]
+	if !ok {
+		s.passes[dc] = &datacenterPass{start: start, end: end}
+		return
+	}
+	if start.Before(p.start) {
+		p.start = start
+	}
+	if end.After(p.end) {
+		p.end = end
+	}
+}
+
+// collectPasses collects the wall-clock duration of the collection of each
+// datacenter, from the start of its first collector run to the end of its
+// last, which attributes slow scrapes to slow datacenters.
+func (s *scrape) collectPasses(ch chan<- prometheus.Metric) {
+	s.mtx.Lock()
+	durations := make(map[string]time.Duration, len(s.passes))
+	for dc, p := range s.passes {
+		durations[dc] = p.end.Sub(p.start)
+	}
+	s.mtx.Unlock()
+
+	for dc, d := range durations {
+		ch <- prometheus.MustNewConstMetric(
+			datacenterCollectDuration, prometheus.GaugeValue, d.Seconds(), dc,
+		)
+	}
+}
+
+// forEachDatacenter runs f concurrently for every datacenter to collect and
+// waits for all of them. Datacenters are collected serially or their start
+// staggered if configured.
+func (s *scrape) forEachDatacenter(f func(dc string)) {
+	dcs := s.datacenters()
+	if s.e.dcSerial {
+		for _, dc := range dcs {
+			if s.ctx.Err() != nil {
+				return
+			}
+			f(dc)
+		}
+		return
+	}
+	s.e.pool.each(len(dcs), func(i int) {
+		if d := staggerDelay(i, len(dcs), s.e.dcStagger); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-s.ctx.Done():
+				t.Stop()
+				return
+			}
+		}
+		f(dcs[i])
+	})
+}
+
+// staggerDelay returns the delay before collecting the ith of n datacenters:
+// a random point of its slot when window is split evenly between them. The
+// first datacenter, usually the local one, starts right away.
+func staggerDelay(i, n int, window time.Duration) time.Duration {
+	if window <= 0 || i == 0 || n < 2 {
+		return 0
+	}
+	slot := window / time.Duration(n)
+	return time.Duration(i)*slot + time.Duration(rand.Int63n(int64(slot)+1))
+}
+
+// catalogServices are the services of a datacenter's catalog.
+type catalogServices struct {
+	once sync.Once
+	err  error
+
+	// count is the number of services in the catalog, names holds the
+	// collected ones with their tags.
+	count     int
+	names     map[string][]string
+	truncated bool
+}
+
+// serviceNames returns the services of the datacenter's catalog with their
+// tags, from the watch cache if enabled.
+func (e *Exporter) serviceNames(ctx context.Context, dc string) (map[string][]string, error) {
+	if e.watches != nil {
+		dw, err := e.watches.datacenter(ctx, dc)
+		if err != nil {
+			e.queryError("/v1/catalog/services", dc, err)
+			return nil, err
+		}
+		v, err := e.watched(dw.services)
+		names, _ := v.(map[string][]string)
+		return names, err
+	}
+
+	opts, cancel := e.queryOptions(ctx, dc, EndpointCatalog)
+	defer cancel()
+	names, meta, err := e.client.Catalog().Services(withFilter(opts, e.servicesFilter))
+	if err != nil {
+		e.queryError("/v1/catalog/services", dc, err)
+		return nil, err
+	}
+	e.indexes.record("/v1/catalog/services", dc, meta.LastIndex)
+	return names, nil
+}
+
+// catalogServices returns the services of the datacenter, querying the
+// catalog only once per scrape for all collectors. The services are truncated
+// to the configured maximum.
+func (s *scrape) catalogServices(dc string) (*catalogServices, error) {
+	s.mtx.Lock()
+	cs, ok := s.services[dc]
+	if !ok {
+		cs = &catalogServices{}
+		s.services[dc] = cs
+	}
+	s.mtx.Unlock()
+
+	cs.once.Do(func() {
+		e := s.e
+		names, err := e.serviceNames(s.ctx, dc)
+		if err != nil {
+			cs.err = err
+			return
+		}
+
+		// Protect both Consul and Prometheus from pathological catalogs.
+		cs.count = len(names)
+		names = e.shard.services(names)
+		if e.maxServices > 0 && len(names) > e.maxServices {
+			logger.Warn("Service catalog truncated", "datacenter", dc, "services", len(names), "max_services", e.maxServices)
+			names = truncateServices(names, e.maxServices)
+			cs.truncated = true
+		}
+		cs.names = names
+		e.snapshot.setServices(dc, names)
+		e.stats.setServices(dc, len(names))
+	})
+	return cs, cs.err
+}
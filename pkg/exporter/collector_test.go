@@ -0,0 +1,56 @@
+package exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestDatacenterPasses(t *testing.T) {
+	e, err := New(ConsulOpts{URI: "http://localhost:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newScrape(context.Background(), e, nil)
+	ch := make(chan prometheus.Metric, 10)
+
+	now := time.Now()
+	s.observe(ch, collectorCatalog, "dc1", now.Add(-3*time.Second))
+	s.observe(ch, collectorHealth, "dc1", now.Add(-time.Second))
+	s.collectPasses(ch)
+	close(ch)
+
+	var passes []float64
+	for m := range ch {
+		if m.Desc() != datacenterCollectDuration {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		passes = append(passes, pb.GetGauge().GetValue())
+	}
+	if len(passes) != 1 || passes[0] < 3 || passes[0] > 4 {
+		t.Errorf("expected a single pass of dc1 spanning both runs, got %v", passes)
+	}
+}
+
+func TestStaggerDelay(t *testing.T) {
+	if d := staggerDelay(0, 4, time.Second); d != 0 {
+		t.Errorf("first datacenter delayed by %s", d)
+	}
+	if d := staggerDelay(2, 4, 0); d != 0 {
+		t.Errorf("delayed by %s without a window", d)
+	}
+	for i := 1; i < 4; i++ {
+		d := staggerDelay(i, 4, time.Second)
+		if min, max := time.Duration(i)*250*time.Millisecond, time.Duration(i+1)*250*time.Millisecond; d < min || d > max {
+			t.Errorf("datacenter %d delayed by %s, want between %s and %s", i, d, min, max)
+		}
+	}
+}
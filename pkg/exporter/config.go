@@ -0,0 +1,115 @@
+package exporter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/hashicorp/hcl"
+)
+
+// Config is the content of the optional configuration file. It is written in
+// HCL, like Consul's own configuration. Lists of objects must use the list
+// syntax (relabel = [{ ... }]), repeated blocks don't decode into slices.
+type Config struct {
+	Relabel     []*RelabelConfig             `hcl:"relabel"`
+	Datacenters map[string]*DatacenterConfig `hcl:"datacenter"`
+
+	// KV maps prefixes to the configuration of the metric their keys are
+	// exported as.
+	KV map[string]*KVConfig `hcl:"kv"`
+	// KVFlag holds the options of the kv.prefix flag's prefix which are
+	// set by flags, its metric is fixed.
+	KVFlag KVConfig `hcl:"-"`
+	// KVBoolValues overrides the mapping of boolean strings for prefixes
+	// with bools enabled.
+	KVBoolValues map[string]int `hcl:"kv_bool_values"`
+	// KVDefaultDeny only exports keys matching the allowlist of their
+	// prefix.
+	KVDefaultDeny bool `hcl:"kv_default_deny"`
+	// KVSensitive are regexes of keys whose names and values must not
+	// appear in logs, errors or the snapshot.
+	KVSensitive []string `hcl:"kv_sensitive"`
+
+	// Probes maps services to the configuration of their probe.
+	Probes map[string]*ProbeConfig `hcl:"probe"`
+
+	// StatusValues overrides the numeric encoding of health check states.
+	StatusValues map[string]int `hcl:"status_values"`
+
+	redactor *redactor
+}
+
+// DatacenterConfig overrides the global query options for a single
+// datacenter. Unset fields fall back to ConsulOpts.
+type DatacenterConfig struct {
+	AllowStale        *bool  `hcl:"allow_stale"`
+	RequireConsistent *bool  `hcl:"require_consistent"`
+	Timeout           string `hcl:"timeout"`
+
+	timeout time.Duration
+}
+
+// numberValue converts a number decoded into an interface{}, which is an int
+// or a float64 depending on its literal, to a float64.
+func numberValue(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// LoadConfig reads and validates the configuration file at filename.
+func LoadConfig(filename string) (*Config, error) {
+	c := &Config{}
+	if filename == "" {
+		return c, nil
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := hcl.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", filename, err)
+	}
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// init validates the configuration and applies the defaults. It is also run
+// by New, so that configurations built in code are valid as well.
+func (c *Config) init() (err error) {
+	for i, rc := range c.Relabel {
+		if err := rc.init(); err != nil {
+			return fmt.Errorf("invalid relabel config #%d: %s", i, err)
+		}
+	}
+	for dc, dcc := range c.Datacenters {
+		if dcc.Timeout == "" {
+			continue
+		}
+		if dcc.timeout, err = time.ParseDuration(dcc.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout for datacenter %s: %s", dc, err)
+		}
+	}
+	for service, pc := range c.Probes {
+		if err := pc.init(); err != nil {
+			return fmt.Errorf("invalid probe of service %s: %s", service, err)
+		}
+	}
+	for status := range c.StatusValues {
+		if _, ok := defaultStatusValues[status]; !ok {
+			return fmt.Errorf("invalid status_values: unknown status %q", status)
+		}
+	}
+	if c.redactor, err = newRedactor(c.KVSensitive); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "consul_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`
+datacenter "dc2" {
+  allow_stale = true
+  timeout     = "2s"
+}
+
+kv "config/limits/" {
+  metric     = "app_max_connections"
+  multiplier = 1024
+  offset     = 0.5
+
+  labels {
+    team = "payments"
+  }
+}
+
+relabel = [
+  {
+    source_labels = ["check"]
+    regex         = "serfHealth"
+    action        = "drop"
+  },
+]
+`)
+	f.Close()
+
+	c, err := LoadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dcc, ok := c.Datacenters["dc2"]
+	if !ok {
+		t.Fatalf("expected config for dc2, got %v", c.Datacenters)
+	}
+	if dcc.AllowStale == nil || !*dcc.AllowStale {
+		t.Errorf("expected allow_stale to be true")
+	}
+	if dcc.RequireConsistent != nil {
+		t.Errorf("expected require_consistent to be unset")
+	}
+	if dcc.timeout != 2*time.Second {
+		t.Errorf("expected timeout of 2s, got %s", dcc.timeout)
+	}
+	kc, ok := c.KV["config/limits/"]
+	if !ok {
+		t.Fatalf("expected kv config for config/limits/, got %v", c.KV)
+	}
+	if kc.Metric != "app_max_connections" || kc.Labels["team"] != "payments" {
+		t.Errorf("unexpected kv config %+v", kc)
+	}
+	if err := kc.init("config/limits/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if kc.multiplier != 1024 || kc.offset != 0.5 {
+		t.Errorf("expected multiplier 1024 and offset 0.5, got %v and %v", kc.multiplier, kc.offset)
+	}
+	if len(c.Relabel) != 1 || c.Relabel[0].Action != relabelDrop {
+		t.Errorf("unexpected relabel configs %v", c.Relabel)
+	}
+}
@@ -0,0 +1,104 @@
+package exporter
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector(collectorConsistency, consistencyCollector{}, false)
+}
+
+// consistencyCollector cross-checks the instances of every service in the
+// catalog against those returned by the health endpoint. Both are views of
+// the same state, differences indicate corrupted registrations. It needs two
+// queries per service.
+type consistencyCollector struct{}
+
+// serviceInstance identifies an instance of a service.
+type serviceInstance struct {
+	node, serviceID string
+}
+
+func (consistencyCollector) collect(s *scrape, ch chan<- prometheus.Metric) {
+	e := s.e
+	s.forEachDatacenter(func(dc string) {
+		ctx, span := e.tracer.start(s.ctx, "collect consistency", "consul.datacenter", dc)
+		defer span.finish(nil)
+		defer s.observe(ch, collectorConsistency, dc, time.Now())
+
+		services, err := s.catalogServices(dc)
+		if err != nil {
+			return
+		}
+		catalogOptions, cancelCatalog := e.queryOptions(ctx, dc, EndpointCatalog)
+		defer cancelCatalog()
+		healthOptions, cancelHealth := e.queryOptions(ctx, dc, EndpointHealth)
+		defer cancelHealth()
+
+		names := make([]string, 0, len(services.names))
+		for name := range services.names {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var (
+			mtx                           sync.Mutex
+			failed                        bool
+			missingHealth, missingCatalog int
+		)
+		e.pool.each(len(names), func(i int) {
+			name := names[i]
+			catalogEntries, _, err := e.client.Catalog().Service(name, "", catalogOptions)
+			if err != nil {
+				e.collectorError(collectorConsistency, "/v1/catalog/service", dc, err, "service", name)
+				mtx.Lock()
+				failed = true
+				mtx.Unlock()
+				return
+			}
+			healthEntries, _, err := e.client.Health().Service(name, "", false, healthOptions)
+			if err != nil {
+				e.collectorError(collectorConsistency, "/v1/health/service", dc, err, "service", name)
+				mtx.Lock()
+				failed = true
+				mtx.Unlock()
+				return
+			}
+
+			inCatalog := make(map[serviceInstance]bool, len(catalogEntries))
+			for _, cs := range catalogEntries {
+				inCatalog[serviceInstance{cs.Node, cs.ServiceID}] = true
+			}
+			inHealth := make(map[serviceInstance]bool, len(healthEntries))
+			for _, se := range healthEntries {
+				inHealth[serviceInstance{se.Node.Node, se.Service.ID}] = true
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			for i := range inCatalog {
+				if !inHealth[i] {
+					missingHealth++
+				}
+			}
+			for i := range inHealth {
+				if !inCatalog[i] {
+					missingCatalog++
+				}
+			}
+		})
+		// Partial counts would hide inconsistencies.
+		if failed {
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(
+			inconsistentInstances, prometheus.GaugeValue, float64(missingHealth), dc, "health",
+		)
+		ch <- prometheus.MustNewConstMetric(
+			inconsistentInstances, prometheus.GaugeValue, float64(missingCatalog), dc, "catalog",
+		)
+	})
+}
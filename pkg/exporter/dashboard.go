@@ -0,0 +1,170 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// dashboard is the subset of the Grafana dashboard model the exporter uses.
+type dashboard struct {
+	UID           string            `json:"uid"`
+	Title         string            `json:"title"`
+	Tags          []string          `json:"tags"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Refresh       string            `json:"refresh"`
+	Time          dashboardTime     `json:"time"`
+	Templating    dashboardVars     `json:"templating"`
+	Panels        []*dashboardPanel `json:"panels"`
+}
+
+type dashboardTime struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type dashboardVars struct {
+	List []dashboardVar `json:"list"`
+}
+
+type dashboardVar struct {
+	Name       string            `json:"name"`
+	Label      string            `json:"label"`
+	Type       string            `json:"type"`
+	Query      string            `json:"query"`
+	Datasource *dashboardDatasrc `json:"datasource,omitempty"`
+	IncludeAll bool              `json:"includeAll,omitempty"`
+	Multi      bool              `json:"multi,omitempty"`
+	AllValue   string            `json:"allValue,omitempty"`
+	// Refresh 2 reloads the values of query variables when the time
+	// range changes.
+	Refresh int `json:"refresh,omitempty"`
+}
+
+type dashboardDatasrc struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type dashboardPanel struct {
+	ID         int               `json:"id"`
+	Title      string            `json:"title"`
+	Type       string            `json:"type"`
+	Datasource *dashboardDatasrc `json:"datasource"`
+	GridPos    dashboardGridPos  `json:"gridPos"`
+	Targets    []dashboardTarget `json:"targets"`
+}
+
+type dashboardGridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type dashboardTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+}
+
+// prometheusDatasource refers to the data source picked by the datasource
+// variable.
+var prometheusDatasource = &dashboardDatasrc{Type: "prometheus", UID: "${datasource}"}
+
+// Dashboard returns a Grafana dashboard in JSON with panels for the metrics
+// of the enabled collectors, whose names start with namespace, Namespace if
+// empty.
+func (e *Exporter) Dashboard(namespace string) []byte {
+	ns := strings.TrimSuffix(namespace, "_")
+	if ns == "" {
+		ns = Namespace
+	}
+	// name renames a metric of the exporter to the namespace. KV metrics
+	// may be named freely and are only renamed with the default prefix.
+	name := func(metric string) string {
+		if strings.HasPrefix(metric, Namespace+"_") {
+			return ns + strings.TrimPrefix(metric, Namespace)
+		}
+		return metric
+	}
+	dc := `datacenter=~"$datacenter"`
+
+	d := &dashboard{
+		UID:           "consul-exporter",
+		Title:         "Consul",
+		Tags:          []string{"consul", "consul_exporter"},
+		SchemaVersion: 36,
+		Refresh:       "1m",
+		Time:          dashboardTime{From: "now-6h", To: "now"},
+		Templating: dashboardVars{List: []dashboardVar{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+			{
+				Name: "datacenter", Label: "Datacenter", Type: "query", Datasource: prometheusDatasource,
+				Query:      fmt.Sprintf("label_values(%s_exporter_collector_duration_seconds, datacenter)", ns),
+				IncludeAll: true, Multi: true, AllValue: ".*", Refresh: 2,
+			},
+		}},
+	}
+	add := func(title, panelType, expr, legend string) {
+		i := len(d.Panels)
+		d.Panels = append(d.Panels, &dashboardPanel{
+			ID:         i + 1,
+			Title:      title,
+			Type:       panelType,
+			Datasource: prometheusDatasource,
+			GridPos:    dashboardGridPos{X: i % 2 * 12, Y: i / 2 * 8, W: 12, H: 8},
+			Targets:    []dashboardTarget{{RefID: "A", Expr: expr, LegendFormat: legend}},
+		})
+	}
+
+	add("Consul up", "stat", ns+"_up", "{{instance}}")
+	if e.enabledCollectors[collectorRaft] {
+		add("Raft peers", "timeseries", ns+"_raft_peers", "{{instance}}")
+		add("Raft leader", "stat", ns+"_raft_leader", "{{instance}}")
+	}
+	if e.enabledCollectors[collectorCatalog] {
+		add("Serf LAN members", "timeseries", fmt.Sprintf("%s_serf_lan_members{%s}", ns, dc), "{{datacenter}}")
+		add("Services", "timeseries", fmt.Sprintf("%s_catalog_services{%s}", ns, dc), "{{datacenter}}")
+	}
+	if e.enabledCollectors[collectorHealth] {
+		add("Critical service checks", "timeseries",
+			fmt.Sprintf(`count by (datacenter, service_name) (%s_health_service_status{status="critical",%s})`, ns, dc), "{{datacenter}} {{service_name}}")
+		add("Failed nodes", "table",
+			fmt.Sprintf(`%s_health_node_status{check_name="Serf Health Status",status="critical",%s}`, ns, dc), "{{datacenter}} {{node}}")
+		if e.healthSummary {
+			add("Unhealthy service instances", "timeseries",
+				fmt.Sprintf("count by (datacenter, service_name) (%s_catalog_service_node_healthy{%s} != %v)", ns, dc, e.statusValues[consul_api.HealthPassing]),
+				"{{datacenter}} {{service_name}}")
+		}
+	}
+	if e.enabledCollectors[collectorKV] {
+		for _, kc := range e.kvConfigs {
+			add("KV "+kc.prefix, "timeseries", name(kc.Metric), "{{key}}")
+		}
+	}
+	if e.enabledCollectors[collectorAgent] {
+		add("Agent", "table", ns+"_agent_info", "{{node}}")
+	}
+	if e.enabledCollectors[collectorPlugins] && e.plugins != nil {
+		add("Plugins up", "stat", ns+"_exporter_plugin_up", "{{plugin}}")
+	}
+	add("Collector duration", "timeseries", fmt.Sprintf(`%s_exporter_collector_duration_seconds{datacenter=~"$datacenter|"}`, ns), "{{collector}} {{datacenter}}")
+
+	out, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// dashboardHandler serves the dashboard for the namespace.
+func dashboardHandler(e *Exporter, namespace string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(e.Dashboard(namespace))
+	})
+}
@@ -0,0 +1,33 @@
+package exporter
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	e, err := New(ConsulOpts{URI: "localhost:8500"}, WithKVPrefix("config/", ".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCollectors([]string{collectorRaft, collectorKV}); err != nil {
+		t.Fatal(err)
+	}
+
+	var d dashboard
+	if err := json.Unmarshal(e.Dashboard("consul_dc1"), &d); err != nil {
+		t.Fatal(err)
+	}
+	exprs := map[string]bool{}
+	for _, p := range d.Panels {
+		exprs[p.Targets[0].Expr] = true
+	}
+	for _, expr := range []string{"consul_dc1_raft_peers", "consul_dc1_catalog_kv"} {
+		if !exprs[expr] {
+			t.Errorf("expected panel for %s, got %v", expr, exprs)
+		}
+	}
+	if exprs[`consul_dc1_catalog_services{datacenter=~"$datacenter"}`] {
+		t.Errorf("expected no panel of the disabled catalog collector")
+	}
+}
@@ -0,0 +1,105 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultDatacentersTTL is the default TTL of the cached datacenters.
+const defaultDatacentersTTL = 5 * time.Minute
+
+// datacenterCache caches the datacenters known to the catalog, which rarely
+// change, for a TTL. Its methods are safe to call on a nil value, which
+// caches nothing.
+type datacenterCache struct {
+	ttl time.Duration
+
+	mtx     sync.Mutex
+	dcs     []string
+	fetched time.Time
+}
+
+func newDatacenterCache(ttl time.Duration) *datacenterCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &datacenterCache{ttl: ttl}
+}
+
+// get returns the cached datacenters if they were fetched less than the TTL
+// before now.
+func (c *datacenterCache) get(now time.Time) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.fetched.IsZero() || now.Sub(c.fetched) >= c.ttl {
+		return nil, false
+	}
+	return c.dcs, true
+}
+
+// set caches the datacenters fetched at now.
+func (c *datacenterCache) set(dcs []string, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.dcs, c.fetched = dcs, now
+}
+
+// knownDatacenters returns the datacenters known to the catalog, from the
+// cache if fresh.
+func (e *Exporter) knownDatacenters(ctx context.Context) ([]string, error) {
+	if dcs, ok := e.dcCache.get(time.Now()); ok {
+		return dcs, nil
+	}
+	var dcs []string
+	if err := e.rawQuery(ctx, "/v1/catalog/datacenters", &dcs); err != nil {
+		return nil, err
+	}
+	e.dcCache.set(dcs, time.Now())
+	return dcs, nil
+}
+
+// unknownDatacenter returns the first of dcs which isn't one of known.
+func unknownDatacenter(dcs, known []string) (string, bool) {
+	for _, dc := range dcs {
+		found := false
+		for _, k := range known {
+			if dc == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return dc, true
+		}
+	}
+	return "", false
+}
+
+// catalogDatacenters returns the datacenters known to the catalog, from the
+// cache if fresh. If they can't be queried, the datacenter of the agent is
+// returned.
+func (s *scrape) catalogDatacenters() []string {
+	e := s.e
+	dcs, err := e.knownDatacenters(s.ctx)
+	if err == nil {
+		return dcs
+	}
+
+	e.queryError("/v1/catalog/datacenters", "", err)
+	var c map[string]map[string]interface{}
+	if err := e.rawQuery(s.ctx, "/v1/agent/self", &c); err != nil {
+		e.queryError("/v1/agent/self", "", err)
+		return nil
+	}
+	if dc, ok := c["Config"]["Datacenter"].(string); ok {
+		return []string{dc}
+	}
+	return nil
+}
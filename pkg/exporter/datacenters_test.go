@@ -0,0 +1,27 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDatacenterCache(t *testing.T) {
+	var disabled *datacenterCache
+	disabled.set([]string{"dc1"}, time.Now())
+	if _, ok := disabled.get(time.Now()); ok {
+		t.Error("expected disabled cache to miss")
+	}
+
+	c := newDatacenterCache(time.Minute)
+	now := time.Now()
+	if _, ok := c.get(now); ok {
+		t.Error("expected empty cache to miss")
+	}
+	c.set([]string{"dc1", "dc2"}, now)
+	if dcs, ok := c.get(now.Add(30 * time.Second)); !ok || len(dcs) != 2 {
+		t.Errorf("expected cached datacenters, got %v", dcs)
+	}
+	if _, ok := c.get(now.Add(time.Minute)); ok {
+		t.Error("expected expired cache to miss")
+	}
+}
@@ -0,0 +1,83 @@
+package exporter
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// exporterStats holds internal state of the exporter for debugging. It is
+// shared by the filtered copies of an exporter.
+type exporterStats struct {
+	activeScrapes int64
+
+	mtx         sync.Mutex
+	datacenters []string
+	durations   map[string]float64
+	// running and last hold the running and last finished collection of
+	// each datacenter.
+	running map[string]*datacenterStatus
+	last    map[string]*datacenterStatus
+}
+
+func newExporterStats() *exporterStats {
+	return &exporterStats{
+		durations: map[string]float64{},
+		running:   map[string]*datacenterStatus{},
+		last:      map[string]*datacenterStatus{},
+	}
+}
+
+// scrapeStarted counts a running scrape, the returned function must be
+// called when it's done.
+func (s *exporterStats) scrapeStarted() func() {
+	atomic.AddInt64(&s.activeScrapes, 1)
+	return func() { atomic.AddInt64(&s.activeScrapes, -1) }
+}
+
+func (s *exporterStats) setDatacenters(dcs []string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.datacenters = dcs
+}
+
+// observe records the duration of the last run of a collector.
+func (s *exporterStats) observe(collector, dc string, d time.Duration) {
+	if dc != "" {
+		collector += "/" + dc
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.durations[collector] = d.Seconds()
+}
+
+// debugVars returns the state published under /debug/vars.
+func (e *Exporter) debugVars() interface{} {
+	s := e.stats
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	durations := make(map[string]float64, len(s.durations))
+	for collector, d := range s.durations {
+		durations[collector] = d
+	}
+	watchIndexes := map[string]uint64{}
+	for _, kc := range e.kvConfigs {
+		if kc.watcher != nil {
+			watchIndexes[kc.prefix] = kc.watcher.lastIndex()
+		}
+	}
+	return map[string]interface{}{
+		"active_scrapes":      atomic.LoadInt64(&s.activeScrapes),
+		"datacenters":         s.datacenters,
+		"collector_durations": durations,
+		"kv_watch_indexes":    watchIndexes,
+	}
+}
+
+// PublishDebugVars publishes the exporter's state under /debug/vars. It may
+// only be called once per process.
+func (e *Exporter) PublishDebugVars() {
+	expvar.Publish("consul_exporter", expvar.Func(e.debugVars))
+}
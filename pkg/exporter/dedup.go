@@ -0,0 +1,43 @@
+package exporter
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// seriesKey identifies a series within a collection.
+type seriesKey struct {
+	desc   *prometheus.Desc
+	labels string
+}
+
+// seriesSet tracks the series sent during a single collection. Consul allows
+// registrations Prometheus can't tell apart, e.g. the same check ID on two
+// instances of a service on one node, and a single duplicate series would
+// fail the whole scrape.
+type seriesSet map[seriesKey]struct{}
+
+// duplicate returns whether a series with the metric's descriptor and label
+// values was already seen, recording it otherwise.
+func (s seriesSet) duplicate(m prometheus.Metric) bool {
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		// Invalid metrics are reported by the registry.
+		return false
+	}
+	values := make([]string, len(pb.Label))
+	for i, lp := range pb.Label {
+		values[i] = lp.GetValue()
+	}
+	key := seriesKey{desc: m.Desc(), labels: strings.Join(values, "\xff")}
+	if _, ok := s[key]; ok {
+		logger.Warn("Dropping duplicate series", "desc", m.Desc().String(), "labels", strings.Join(values, ","))
+		duplicateSeries.Inc()
+		return true
+	}
+	s[key] = struct{}{}
+	return false
+}
@@ -0,0 +1,24 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSeriesSet(t *testing.T) {
+	seen := seriesSet{}
+	metric := func(checkID, node string) prometheus.Metric {
+		return prometheus.MustNewConstMetric(nodeChecks, prometheus.GaugeValue, 1, checkID, "Serf Health Status", node, "passing", "dc1")
+	}
+
+	if seen.duplicate(metric("serfHealth", "n1")) {
+		t.Error("expected first series not to be a duplicate")
+	}
+	if seen.duplicate(metric("serfHealth", "n2")) {
+		t.Error("expected series with other labels not to be a duplicate")
+	}
+	if !seen.duplicate(metric("serfHealth", "n1")) {
+		t.Error("expected repeated series to be a duplicate")
+	}
+}
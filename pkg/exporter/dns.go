@@ -0,0 +1,91 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultDNSPort is the port of the DNS interface of Consul agents.
+const defaultDNSPort = "8600"
+
+func init() {
+	registerCollector(collectorDNS, dnsCollector{}, false)
+}
+
+// dnsCollector resolves the configured services through the DNS interface of
+// the Consul agent, the path most applications use to discover services. It
+// does nothing without services to resolve.
+type dnsCollector struct{}
+
+func (dnsCollector) collect(s *scrape, ch chan<- prometheus.Metric) {
+	e := s.e
+	if e.dns == nil {
+		return
+	}
+	defer e.observe(ch, collectorDNS, "", time.Now())
+
+	e.pool.each(len(e.dns.services), func(i int) {
+		service := e.dns.services[i]
+		start := time.Now()
+		answers, err := e.dns.lookup(s.ctx, service)
+		if s.ctx.Err() != nil {
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(dnsLookupDuration, prometheus.GaugeValue, time.Since(start).Seconds(), service)
+		if err != nil {
+			logger.Error("DNS lookup failed", "service", service, "server", e.dns.addr, "err", err)
+			e.failures.addCollector(collectorDNS)
+			ch <- prometheus.MustNewConstMetric(dnsLookupSuccess, prometheus.GaugeValue, 0, service)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(dnsLookupSuccess, prometheus.GaugeValue, 1, service)
+		ch <- prometheus.MustNewConstMetric(dnsAnswers, prometheus.GaugeValue, float64(answers), service)
+	})
+}
+
+// dnsProbe resolves services through the DNS interface of a Consul agent.
+type dnsProbe struct {
+	addr     string
+	domain   string
+	services []string
+	resolver *net.Resolver
+}
+
+func newDNSProbe(addr, domain string, services []string) *dnsProbe {
+	return &dnsProbe{
+		addr:     addr,
+		domain:   domain,
+		services: services,
+		resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+// name returns the domain name of a service, which may be prefixed with a
+// tag, e.g. primary.db.
+func (p *dnsProbe) name(service string) string {
+	return service + ".service." + p.domain + "."
+}
+
+// lookup returns the number of SRV records of a service, one per passing
+// instance. Services without passing instances have none.
+func (p *dnsProbe) lookup(ctx context.Context, service string) (int, error) {
+	_, addrs, err := p.resolver.LookupSRV(ctx, "", "", p.name(service))
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(addrs), nil
+}
@@ -0,0 +1,15 @@
+package exporter
+
+import "testing"
+
+func TestDNSProbeName(t *testing.T) {
+	p := newDNSProbe("127.0.0.1:8600", "consul", nil)
+	for service, expected := range map[string]string{
+		"web":        "web.service.consul.",
+		"primary.db": "primary.db.service.consul.",
+	} {
+		if got := p.name(service); got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	}
+}
@@ -0,0 +1,1244 @@
+// Package exporter collects metrics of a Consul cluster. Its Exporter is a
+// prometheus.Collector, so that it can be embedded into any binary, and
+// serves the exporter's auxiliary HTTP endpoints.
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// Namespace is the prefix of the names of all exported metrics.
+const Namespace = "consul"
+
+const (
+
+	// Consul API endpoints with individually configurable query options,
+	// the keys of ConsulOpts.Consistency.
+	EndpointCatalog = "catalog"
+	EndpointHealth  = "health"
+	EndpointKV      = "kv"
+
+	// Consistency modes of Consul reads.
+	ConsistencyStale      = "stale"
+	ConsistencyDefault    = "default"
+	ConsistencyConsistent = "consistent"
+
+	// Identifiers of health checks populating the check label.
+	CheckLabelID   = "id"
+	CheckLabelName = "name"
+
+	// Names of the collectors, which can be selected with collect[] at scrape
+	// time.
+	collectorRaft        = "raft"
+	collectorCatalog     = "catalog"
+	collectorHealth      = "health"
+	collectorKV          = "kv"
+	collectorAgent       = "agent"
+	collectorPlugins     = "plugins"
+	collectorConsistency = "consistency"
+	collectorWAN         = "wan"
+	collectorDNS         = "dns"
+	collectorProbe       = "probe"
+
+	keyValuesHelp = "The values for selected keys in Consul's key/value catalog. Keys with non-numeric values are omitted."
+
+	// maxMetaValueLength caps the length of metadata values exported as
+	// label values.
+	maxMetaValueLength = 128
+	// maxKVInfoValueLength is the maximum length of KV values exported as
+	// label values, longer values are skipped.
+	maxKVInfoValueLength = 128
+)
+
+var (
+	up = newDesc(
+		prometheus.BuildFQName(Namespace, "", "up"),
+		"Was the last query of Consul successful.",
+		nil,
+	)
+	clusterServers = newDesc(
+		prometheus.BuildFQName(Namespace, "", "raft_peers"),
+		"How many peers (servers) are in the Raft cluster.",
+		nil,
+	)
+	clusterLeader = newDesc(
+		prometheus.BuildFQName(Namespace, "", "raft_leader"),
+		"Does Raft cluster have a leader (according to this node).",
+		nil,
+	)
+	nodeCount = newDesc(
+		prometheus.BuildFQName(Namespace, "", "serf_lan_members"),
+		"How many members are in the cluster.",
+		[]string{"datacenter"},
+	)
+	segmentMembers = newDesc(
+		prometheus.BuildFQName(Namespace, "", "serf_lan_segment_members"),
+		"How many LAN members known to the queried agent are in a network segment, by status.",
+		[]string{"segment", "status"},
+	)
+	serviceCount = newDesc(
+		prometheus.BuildFQName(Namespace, "", "catalog_services"),
+		"How many services are in the cluster.",
+		[]string{"datacenter"},
+	)
+	serviceTag = newDesc(
+		prometheus.BuildFQName(Namespace, "", "service_tag"),
+		"Tags of a service.",
+		[]string{"service_id", "node", "tag"},
+	)
+	serviceTagLabeled = newDesc(
+		prometheus.BuildFQName(Namespace, "", "service_tag"),
+		"Tags of a service.",
+		[]string{"service_id", "node", "tag", "service_name", "datacenter"},
+	)
+	serviceNodesHealthy = newDesc(
+		prometheus.BuildFQName(Namespace, "", "catalog_service_node_healthy"),
+		"Is this service healthy on this node?",
+		[]string{"service_id", "node", "service_name", "datacenter", "tags"},
+	)
+	nodeChecks = newDesc(
+		prometheus.BuildFQName(Namespace, "", "health_node_status"),
+		"Status of health checks associated with a node.",
+		[]string{"check", "check_name", "node", "status", "datacenter"},
+	)
+	nodeChecksTyped = newDesc(
+		prometheus.BuildFQName(Namespace, "", "health_node_status"),
+		"Status of health checks associated with a node.",
+		[]string{"check", "check_name", "node", "status", "datacenter", "check_type"},
+	)
+	serviceChecks = newDesc(
+		prometheus.BuildFQName(Namespace, "", "health_service_status"),
+		"Status of health checks associated with a service.",
+		[]string{"check", "check_name", "node", "service_id", "service_name", "status", "datacenter", "tags"},
+	)
+	serviceChecksTyped = newDesc(
+		prometheus.BuildFQName(Namespace, "", "health_service_status"),
+		"Status of health checks associated with a service.",
+		[]string{"check", "check_name", "node", "service_id", "service_name", "status", "datacenter", "tags", "check_type"},
+	)
+	checkStateDuration = newDesc(
+		prometheus.BuildFQName(Namespace, "health", "check_state_duration_seconds"),
+		"Time a health check has been in its current status, counted from when the exporter first saw it.",
+		[]string{"check", "node", "service_id", "datacenter", "status"},
+	)
+	deregisterRemaining = newDesc(
+		prometheus.BuildFQName(Namespace, "health", "check_deregister_remaining_seconds"),
+		"Time left until Consul deregisters the service instance of a critical check with DeregisterCriticalServiceAfter.",
+		[]string{"check", "node", "service_id", "datacenter"},
+	)
+	checkInfo = newDesc(
+		prometheus.BuildFQName(Namespace, "health", "check_info"),
+		"Notes of a health check, e.g. a runbook link, truncated to 256 characters.",
+		[]string{"check", "check_name", "node", "service_id", "datacenter", "notes"},
+	)
+	servicesTruncated = newDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "services_truncated"),
+		"Whether the service catalog exceeded --catalog.max-services and was truncated.",
+		[]string{"datacenter"},
+	)
+	collectorDuration = newDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "collector_duration_seconds"),
+		"Duration of the last run of a collector, per datacenter for catalog and health.",
+		[]string{"collector", "datacenter"},
+	)
+	datacenterCollectDuration = newDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "dc_collect_duration_seconds"),
+		"Wall-clock duration of the last collection of a datacenter by the catalog, health and consistency collectors.",
+		[]string{"datacenter"},
+	)
+	pluginUp = newDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "plugin_up"),
+		"Whether the last run of a plugin succeeded.",
+		[]string{"plugin"},
+	)
+	lastCollectSuccess = newDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "last_collect_success_timestamp_seconds"),
+		"Unix time of the last collection without failed queries, per collector and overall with an empty collector label.",
+		[]string{"collector"},
+	)
+	collectorSuccess = newDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "collector_success"),
+		"Whether the collector ran without failed queries during the last collection.",
+		[]string{"collector"},
+	)
+	standby = newDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "standby"),
+		"Whether the exporter stands by because another replica holds the leader lock.",
+		nil,
+	)
+	scrapeTimedOut = newDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "scrape_timeout"),
+		"Whether the last collection was cut short by the scrape timeout.",
+		nil,
+	)
+	indexSpread = newDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "index_spread"),
+		"Difference between the highest and lowest Raft index returned by the queries of a datacenter during the last collection.",
+		[]string{"datacenter"},
+	)
+	agentInfo = newDesc(
+		prometheus.BuildFQName(Namespace, "agent", "info"),
+		"Information about the Consul agent queried by the exporter.",
+		[]string{"node", "datacenter", "version", "server"},
+	)
+	agentGoroutines = newDesc(
+		prometheus.BuildFQName(Namespace, "agent", "goroutines"),
+		"Number of goroutines of the Consul agent queried by the exporter.",
+		[]string{"node"},
+	)
+	agentAllocBytes = newDesc(
+		prometheus.BuildFQName(Namespace, "agent", "alloc_bytes"),
+		"Bytes allocated on the heap by the Consul agent queried by the exporter.",
+		[]string{"node"},
+	)
+	agentGCPause = newDesc(
+		prometheus.BuildFQName(Namespace, "agent", "gc_pause_seconds_total"),
+		"Total time the Consul agent queried by the exporter was paused by garbage collection.",
+		[]string{"node"},
+	)
+	wanReachable = newDesc(
+		prometheus.BuildFQName(Namespace, "wan", "datacenter_reachable"),
+		"Whether a stale catalog query of a remote datacenter succeeded.",
+		[]string{"datacenter"},
+	)
+	wanLatency = newDesc(
+		prometheus.BuildFQName(Namespace, "wan", "datacenter_latency_seconds"),
+		"Duration of the last successful stale catalog query of a remote datacenter.",
+		[]string{"datacenter"},
+	)
+	dnsLookupSuccess = newDesc(
+		prometheus.BuildFQName(Namespace, "dns", "lookup_success"),
+		"Whether the last DNS lookup of a service through the Consul agent succeeded.",
+		[]string{"service"},
+	)
+	dnsLookupDuration = newDesc(
+		prometheus.BuildFQName(Namespace, "dns", "lookup_duration_seconds"),
+		"Duration of the last DNS lookup of a service through the Consul agent.",
+		[]string{"service"},
+	)
+	dnsAnswers = newDesc(
+		prometheus.BuildFQName(Namespace, "dns", "answers"),
+		"Number of SRV records returned by the last DNS lookup of a service, one per passing instance.",
+		[]string{"service"},
+	)
+	probeSuccess = newDesc(
+		prometheus.BuildFQName(Namespace, "probe", "success"),
+		"Whether the last probe of a passing instance of a service succeeded.",
+		[]string{"service"},
+	)
+	probeDuration = newDesc(
+		prometheus.BuildFQName(Namespace, "probe", "duration_seconds"),
+		"Duration of the last probe of a passing instance of a service.",
+		[]string{"service"},
+	)
+	serviceTagInstances = newDesc(
+		prometheus.BuildFQName(Namespace, "health", "service_tag_instances"),
+		"Number of instances of a service with a tag broken down by --health.tag-breakdown.",
+		[]string{"service_name", "tag", "datacenter"},
+	)
+	serviceTagHealthy = newDesc(
+		prometheus.BuildFQName(Namespace, "health", "service_tag_healthy_instances"),
+		"Number of passing instances of a service with a tag broken down by --health.tag-breakdown.",
+		[]string{"service_name", "tag", "datacenter"},
+	)
+	inconsistentInstances = newDesc(
+		prometheus.BuildFQName(Namespace, "catalog", "inconsistent_instances"),
+		"Number of service instances in the catalog but missing from the health endpoint (missing=health), or vice versa (missing=catalog).",
+		[]string{"datacenter", "missing"},
+	)
+	agentOutOfSync = newDesc(
+		prometheus.BuildFQName(Namespace, "agent", "out_of_sync"),
+		"Number of services or checks registered with the agent which differ from the catalog's, by kind.",
+		[]string{"node", "kind"},
+	)
+	catalogIndex = newDesc(
+		prometheus.BuildFQName(Namespace, "catalog", "index"),
+		"Highest Raft index returned by an endpoint during the last collection.",
+		[]string{"endpoint", "datacenter"},
+	)
+
+	invalidLabelCharRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+	// defaultStatusValues is the numeric encoding of health check states,
+	// which can be overridden in the configuration file.
+	defaultStatusValues = map[string]float64{
+		consul_api.HealthPassing:  1,
+		consul_api.HealthWarning:  2,
+		consul_api.HealthCritical: 3,
+		consul_api.HealthMaint:    0,
+	}
+)
+
+// Exporter collects Consul stats from the given server and exports them using
+// the prometheus metrics package.
+type Exporter struct {
+	client        *consul_api.Client
+	kvConfigs     []*KVConfig
+	kvTxn         bool
+	healthSummary bool
+	maxServices   int
+
+	nodeMetaKeys    []string
+	nodeMeta        *prometheus.Desc
+	serviceMetaKeys []string
+	serviceMeta     *prometheus.Desc
+	// serviceTag is serviceTag or, with WithServiceTagLabels,
+	// serviceTagLabeled.
+	serviceTag *prometheus.Desc
+
+	relabeler     *relabeler
+	redactor      *redactor
+	statusValues  map[string]float64
+	checksExclude *regexp.Regexp
+	checkType     bool
+	checkByName   bool
+	checkStates   *checkStates
+	// breakdownTags are the tags for which the instances of each service
+	// are counted.
+	breakdownTags map[string]bool
+	// metricsFilter selects the metric families served by MetricsHandler.
+	metricsFilter *metricsFilter
+
+	baseOptions consul_api.QueryOptions
+	timeout     time.Duration
+	consistency map[string]string
+	datacenters map[string]*DatacenterConfig
+
+	nodesFilter    string
+	servicesFilter string
+	healthFilter   string
+	filterKinds    bool
+	shard          shard
+
+	// enabledCollectors are the collectors to run, collectors restricts
+	// them further for a single scrape, nil meaning no restriction.
+	enabledCollectors map[string]bool
+	collectors        map[string]bool
+	// datacenterNames restricts collection to the given datacenters instead
+	// of all datacenters known to the catalog.
+	datacenterNames []string
+	// kvScoped is set when kv.prefix of a scrape narrowed the prefix of the
+	// kv.prefix flag.
+	kvScoped bool
+	// ctx is the context of the scrape, usually that of its HTTP request.
+	// Canceling it aborts the outstanding queries.
+	ctx context.Context
+
+	// snapshots holds the state seen by the last full collection, snapshot
+	// records the state of the running one.
+	snapshots *snapshotStore
+	snapshot  *snapshot
+
+	readiness *readiness
+	stats     *exporterStats
+	tracer    *tracer
+	plugins   *plugins
+	dns       *dnsProbe
+	probes    map[string]*ProbeConfig
+	election  *leaderElection
+	pool      *workerPool
+	dcCache   *datacenterCache
+	// scrapeTimeout is the deadline of a whole collection, after which
+	// the remaining collectors are abandoned.
+	scrapeTimeout time.Duration
+	// dcStagger spreads the start of the datacenters' collections over a
+	// window, dcSerial collects them one after the other.
+	dcStagger time.Duration
+	dcSerial  bool
+	// watches hold the catalog and health state of each datacenter if they
+	// are watched.
+	watches *catalogWatches
+	// cache holds the metrics of the last background or on-demand
+	// collection, if enabled.
+	cache *metricsCache
+
+	// success holds the times of the last successful collections, failures
+	// and indexes record the failed collectors and the Raft indexes seen by
+	// the running one.
+	success  *collectSuccess
+	failures *collectFailures
+	indexes  *queryIndexes
+}
+
+// ConsulOpts configures the connection to Consul and the queries of the
+// exporter.
+type ConsulOpts struct {
+	// URI is the HTTP API address of a Consul server or agent, with an
+	// optional http:// or https:// scheme.
+	URI        string
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+	Timeout    time.Duration
+
+	// AllowStale and RequireConsistent are the global query options.
+	AllowStale        bool
+	RequireConsistent bool
+	// Consistency maps endpoints to the consistency mode of their reads,
+	// overriding the global query options.
+	Consistency map[string]string
+}
+
+// New returns an initialized Exporter connecting to Consul with opts and
+// configured by the given options.
+func New(opts ConsulOpts, options ...Option) (*Exporter, error) {
+	o := defaultOptions()
+	for _, option := range options {
+		option(o)
+	}
+	cfg := o.cfg
+
+	if o.kvWatch && o.kvTxn {
+		return nil, fmt.Errorf("KV watches and transactions are mutually exclusive")
+	}
+	if o.serviceWatch && !o.catalogWatch {
+		return nil, fmt.Errorf("service health watches require catalog watches")
+	}
+	if o.serviceWatch && o.healthFilter != "" {
+		return nil, fmt.Errorf("service health watches and health filters are mutually exclusive")
+	}
+	for _, f := range []struct{ name, expr string }{
+		{"nodes", o.nodesFilter}, {"services", o.servicesFilter}, {"health", o.healthFilter},
+	} {
+		if err := ValidateFilter(f.expr); err != nil {
+			return nil, fmt.Errorf("%s filter: %s", f.name, err)
+		}
+	}
+	shard, err := newShard(o.shardIndex, o.shardTotal)
+	if err != nil {
+		return nil, err
+	}
+	nodeMetaLabels, err := metaLabelNames(o.nodeMetaKeys)
+	if err != nil {
+		return nil, fmt.Errorf("node metadata: %s", err)
+	}
+	serviceMetaLabels, err := metaLabelNames(o.serviceMetaKeys)
+	if err != nil {
+		return nil, fmt.Errorf("service metadata: %s", err)
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	if err := cfg.init(); err != nil {
+		return nil, err
+	}
+
+	u, err := consulURL(opts.URI)
+	if err != nil {
+		return nil, err
+	}
+	uri := u.String()
+
+	tlsConfig := consul_api.TLSConfig{
+		Address:  opts.ServerName,
+		CAFile:   opts.CAFile,
+		CertFile: opts.CertFile,
+		KeyFile:  opts.KeyFile,
+	}
+	config := consul_api.DefaultConfig()
+	config.Address = u.Host
+	config.Scheme = u.Scheme
+	config.TLSConfig = tlsConfig
+	if o.token != "" {
+		config.Token = o.token
+	}
+	config.HttpClient, err = consul_api.NewHttpClient(config.Transport, config.TLSConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %s", err)
+	}
+	// Requests time out in the transport, only once the rate limiter let
+	// them through, so that throttling delays them rather than failing them.
+	config.HttpClient.Transport = instrumentedTransport{
+		next:    config.HttpClient.Transport,
+		limiter: newRateLimiter(o.maxRPS),
+		timeout: opts.Timeout,
+	}
+
+	client, err := consul_api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Blocking queries of watches and locks outlive any request timeout.
+	// They aren't instrumented, as their latency would swamp that of regular
+	// requests.
+	var watchClient *consul_api.Client
+	if o.kvWatch || o.catalogWatch || o.leaderKey != "" {
+		watchConfig := *config
+		watchConfig.HttpClient, err = consul_api.NewHttpClient(config.Transport, config.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		if watchClient, err = consul_api.NewClient(&watchConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	baseOptions := consul_api.QueryOptions{
+		AllowStale:        opts.AllowStale,
+		RequireConsistent: opts.RequireConsistent,
+		UseCache:          o.agentCache,
+		MaxAge:            o.cacheMaxAge,
+	}
+
+	// Init our exporter.
+	e := &Exporter{
+		client:          client,
+		baseOptions:     baseOptions,
+		kvTxn:           o.kvTxn,
+		healthSummary:   o.healthSummary,
+		maxServices:     o.maxServices,
+		nodeMetaKeys:    o.nodeMetaKeys,
+		serviceMetaKeys: o.serviceMetaKeys,
+		timeout:         opts.Timeout,
+		consistency:     opts.Consistency,
+		nodesFilter:     o.nodesFilter,
+		servicesFilter:  andFilters(o.servicesFilter, kindFilter(o.includeKinds, o.excludeKinds)),
+		filterKinds:     len(o.includeKinds) > 0 || len(o.excludeKinds) > 0,
+		shard:           shard,
+		healthFilter:    o.healthFilter,
+		datacenters:     cfg.Datacenters,
+		probes:          cfg.Probes,
+		snapshots:       &snapshotStore{},
+		readiness:       newReadiness(),
+		stats:           newExporterStats(),
+		success:         newCollectSuccess(),
+		pool:            newWorkerPool(o.workers),
+		scrapeTimeout:   o.scrapeTimeout,
+		dcStagger:       o.dcStagger,
+		dcSerial:        o.dcSerial,
+		dcCache:         newDatacenterCache(o.datacentersTTL),
+	}
+	e.enabledCollectors = map[string]bool{}
+	for name, enabled := range Collectors() {
+		e.enabledCollectors[name] = enabled
+	}
+	if e.kvConfigs, err = kvConfigs(o.kvPrefix, o.kvFilter, cfg); err != nil {
+		return nil, err
+	}
+	if o.kvTxn && len(e.kvConfigs) > maxTxnOps {
+		return nil, fmt.Errorf("a KV transaction can read at most %d prefixes, got %d", maxTxnOps, len(e.kvConfigs))
+	}
+	if o.kvWatch {
+		opts, _ := e.baseQueryOptions("", EndpointKV)
+		for _, kc := range e.kvConfigs {
+			kc.watcher = newKVWatcher(watchClient, kc.prefix, opts)
+			go kc.watcher.run()
+		}
+	}
+	if len(cfg.Relabel) > 0 {
+		e.relabeler = newRelabeler(cfg.Relabel)
+	}
+	e.redactor = cfg.redactor
+	if o.catalogWatch {
+		e.watches = newCatalogWatches(watchClient, e, o.serviceWatch)
+	}
+	if o.leaderKey != "" {
+		if e.election, err = newLeaderElection(watchClient, o.leaderKey, e.readiness); err != nil {
+			return nil, err
+		}
+		go e.election.run()
+	}
+	if o.pluginDir != "" {
+		e.plugins = newPlugins(o.pluginDir, o.pluginTimeout, consulEnv(opts, uri, o.token))
+	}
+	if e.metricsFilter, err = newMetricsFilter(o.metricsInclude, o.metricsExclude); err != nil {
+		return nil, err
+	}
+	if len(o.dnsServices) > 0 {
+		addr := o.dnsAddr
+		if addr == "" {
+			addr = net.JoinHostPort(u.Hostname(), defaultDNSPort)
+		}
+		e.dns = newDNSProbe(addr, o.dnsDomain, o.dnsServices)
+	}
+	if len(o.breakdownTags) > 0 {
+		e.breakdownTags = make(map[string]bool, len(o.breakdownTags))
+		for _, tag := range o.breakdownTags {
+			e.breakdownTags[tag] = true
+		}
+	}
+	e.checkType = o.checkType
+	if o.checkStates {
+		e.checkStates = newCheckStates()
+	}
+	switch o.checkLabel {
+	case "", CheckLabelID:
+	case CheckLabelName:
+		e.checkByName = true
+	default:
+		return nil, fmt.Errorf("invalid check label %q", o.checkLabel)
+	}
+	if o.checksExclude != "" {
+		if e.checksExclude, err = regexp.Compile(o.checksExclude); err != nil {
+			return nil, fmt.Errorf("invalid checks exclude regex: %s", err)
+		}
+	}
+	e.statusValues = make(map[string]float64, len(defaultStatusValues))
+	for status, value := range defaultStatusValues {
+		e.statusValues[status] = value
+	}
+	for status, value := range cfg.StatusValues {
+		e.statusValues[status] = float64(value)
+	}
+
+	// Metadata is only exported for an explicit allowlist of keys, arbitrary
+	// user-set metadata would otherwise create unbounded label values.
+	if len(o.nodeMetaKeys) > 0 {
+		e.nodeMeta = newDesc(
+			prometheus.BuildFQName(Namespace, "", "node_meta_info"),
+			"Allowlisted metadata of a node.",
+			append([]string{"node", "datacenter"}, nodeMetaLabels...),
+		)
+	}
+	e.serviceTag = serviceTag
+	if o.tagLabels {
+		e.serviceTag = serviceTagLabeled
+	}
+	if len(o.serviceMetaKeys) > 0 {
+		e.serviceMeta = newDesc(
+			prometheus.BuildFQName(Namespace, "", "service_meta_info"),
+			"Allowlisted metadata of a service instance.",
+			append([]string{"service_id", "node", "service_name", "datacenter"}, serviceMetaLabels...),
+		)
+	}
+	return e, nil
+}
+
+// kindFilter returns a filter expression selecting services by kind.
+func kindFilter(include, exclude []string) string {
+	kind := func(k string) string {
+		if k == "typical" {
+			k = ""
+		}
+		return strconv.Quote(k)
+	}
+
+	var exprs []string
+	if len(include) > 0 {
+		var or []string
+		for _, k := range include {
+			or = append(or, "ServiceKind == "+kind(k))
+		}
+		exprs = append(exprs, "("+strings.Join(or, " or ")+")")
+	}
+	for _, k := range exclude {
+		exprs = append(exprs, "ServiceKind != "+kind(k))
+	}
+	return strings.Join(exprs, " and ")
+}
+
+// andFilters combines two filter expressions, either of which may be empty.
+func andFilters(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	return "(" + a + ") and (" + b + ")"
+}
+
+// metaLabelNames turns metadata keys into valid, prefixed label names. Keys
+// mapping to the same label, like a-b and a_b or repeated keys, are rejected.
+func metaLabelNames(keys []string) ([]string, error) {
+	names := make([]string, len(keys))
+	seen := make(map[string]string, len(keys))
+	for i, key := range keys {
+		names[i] = "meta_" + invalidLabelCharRE.ReplaceAllString(key, "_")
+		if other, ok := seen[names[i]]; ok {
+			return nil, fmt.Errorf("keys %q and %q both map to label %s", other, key, names[i])
+		}
+		seen[names[i]] = key
+	}
+	return names, nil
+}
+
+// metaLabelValues returns the sanitized values of the allowlisted keys.
+// Missing keys result in an empty label value.
+func metaLabelValues(keys []string, meta map[string]string) []string {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = sanitizeMetaValue(meta[key])
+	}
+	return values
+}
+
+// sanitizeMetaValue makes sure a metadata value is valid UTF-8 and no longer
+// than maxMetaValueLength runes.
+func sanitizeMetaValue(value string) string {
+	r := []rune(strings.ToValidUTF8(value, "\uFFFD"))
+	if len(r) > maxMetaValueLength {
+		r = r[:maxMetaValueLength]
+	}
+	return string(r)
+}
+
+// withCollectors returns a copy of the exporter which only runs the named
+// collectors.
+func (e *Exporter) withCollectors(names []string) (*Exporter, error) {
+	collectors := make(map[string]bool, len(names))
+	for _, name := range names {
+		if _, ok := collectorRegistry[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		if !e.enabledCollectors[name] {
+			return nil, fmt.Errorf("collector %q is disabled", name)
+		}
+		collectors[name] = true
+	}
+
+	filtered := *e
+	filtered.collectors = collectors
+	return &filtered, nil
+}
+
+// withDatacenters returns a copy of the exporter which only collects the given
+// datacenters.
+func (e *Exporter) withDatacenters(dcs []string) *Exporter {
+	filtered := *e
+	filtered.datacenterNames = dcs
+	return &filtered
+}
+
+// withKVPrefix returns a copy of the exporter whose kv collector only
+// collects the keys below prefix, which must be below the prefix of the
+// kv.prefix flag. The prefixes of the configuration file aren't collected.
+func (e *Exporter) withKVPrefix(prefix string) (*Exporter, error) {
+	var root *KVConfig
+	for _, kc := range e.kvConfigs {
+		if kc.flag {
+			root = kc
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("kv.prefix can only narrow the prefix of the kv.prefix flag, which isn't set")
+	}
+	if !strings.HasPrefix(prefix, root.prefix) {
+		return nil, fmt.Errorf("kv.prefix %q is not below %q", prefix, root.prefix)
+	}
+
+	kc := *root
+	kc.prefix = prefix
+	// The watch covers the whole root prefix.
+	kc.watcher = nil
+	filtered := *e
+	filtered.kvConfigs = []*KVConfig{&kc}
+	filtered.kvScoped = true
+	return &filtered, nil
+}
+
+// unrestricted returns whether the exporter collects everything, not just the
+// collectors, datacenters or KV prefix requested by a scrape.
+func (e *Exporter) unrestricted() bool {
+	return e.collectors == nil && e.datacenterNames == nil && !e.kvScoped
+}
+
+// withContext returns a copy of the exporter collecting with the given
+// context.
+func (e *Exporter) withContext(ctx context.Context) *Exporter {
+	scoped := *e
+	scoped.ctx = ctx
+	return &scoped
+}
+
+// SetCollectors sets the collectors run by Collect, instead of the ones
+// enabled by default. See Collectors for their names. It must be called
+// before the first collection.
+func (e *Exporter) SetCollectors(names []string) error {
+	enabled := make(map[string]bool, len(names))
+	for _, name := range names {
+		if _, ok := collectorRegistry[name]; !ok {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+		enabled[name] = true
+	}
+	e.enabledCollectors = enabled
+	return nil
+}
+
+// enabled returns whether the named collector should run.
+func (e *Exporter) enabled(name string) bool {
+	return e.enabledCollectors[name] && (e.collectors == nil || e.collectors[name])
+}
+
+// Describe describes all the metrics ever exported by the Consul exporter. It
+// implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- up
+	ch <- clusterServers
+	ch <- clusterLeader
+	ch <- nodeCount
+	ch <- segmentMembers
+	ch <- serviceCount
+	ch <- serviceNodesHealthy
+	if e.checkType {
+		ch <- nodeChecksTyped
+		ch <- serviceChecksTyped
+	} else {
+		ch <- nodeChecks
+		ch <- serviceChecks
+	}
+	for _, kc := range e.kvConfigs {
+		ch <- kc.desc
+		if kc.infoDesc != nil {
+			ch <- kc.infoDesc
+		}
+		if kc.flagsDesc != nil {
+			ch <- kc.flagsDesc
+		}
+		if kc.indexDesc != nil {
+			ch <- kc.indexDesc
+		}
+	}
+	ch <- e.serviceTag
+	ch <- checkInfo
+	ch <- checkStateDuration
+	ch <- deregisterRemaining
+	ch <- servicesTruncated
+	ch <- collectorDuration
+	ch <- datacenterCollectDuration
+	ch <- lastCollectSuccess
+	ch <- collectorSuccess
+	ch <- catalogIndex
+	ch <- indexSpread
+	ch <- scrapeTimedOut
+	ch <- standby
+	ch <- agentInfo
+	ch <- agentGoroutines
+	ch <- agentAllocBytes
+	ch <- agentGCPause
+	ch <- wanReachable
+	ch <- wanLatency
+	ch <- dnsLookupSuccess
+	ch <- dnsLookupDuration
+	ch <- dnsAnswers
+	ch <- probeSuccess
+	ch <- probeDuration
+	ch <- agentOutOfSync
+	ch <- inconsistentInstances
+	ch <- serviceTagInstances
+	ch <- serviceTagHealthy
+	ch <- pluginUp
+	for _, c := range instrumentation {
+		c.Describe(ch)
+	}
+	if e.nodeMeta != nil {
+		ch <- e.nodeMeta
+	}
+	if e.serviceMeta != nil {
+		ch <- e.serviceMeta
+	}
+}
+
+// Collect fetches the stats from configured Consul location and delivers them
+// as Prometheus metrics. It implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	// Relabel and deduplicate metrics before they are emitted.
+	metrics := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		seen := seriesSet{}
+		for m := range metrics {
+			if e.relabeler != nil {
+				if m = e.relabeler.relabelMetric(m); m == nil {
+					continue
+				}
+			}
+			if !seen.duplicate(m) {
+				ch <- m
+			}
+		}
+	}()
+	e.collect(metrics)
+	close(metrics)
+	<-done
+
+	// The instrumentation is collected last, so that it counts the
+	// duplicates of this collection.
+	for _, c := range instrumentation {
+		e.collectRelabeled(ch, c)
+	}
+}
+
+// collectRelabeled sends the relabeled metrics of c to ch.
+func (e *Exporter) collectRelabeled(ch chan<- prometheus.Metric, c prometheus.Collector) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(metrics)
+		close(metrics)
+	}()
+	for m := range metrics {
+		if e.relabeler != nil {
+			if m = e.relabeler.relabelMetric(m); m == nil {
+				continue
+			}
+		}
+		ch <- m
+	}
+}
+
+func (e *Exporter) collect(ch chan<- prometheus.Metric) {
+	if e.election != nil {
+		if e.election.standby() {
+			ch <- prometheus.MustNewConstMetric(standby, prometheus.GaugeValue, 1)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(standby, prometheus.GaugeValue, 0)
+	}
+	defer e.stats.scrapeStarted()()
+
+	running := *e
+	running.failures = newCollectFailures()
+	running.indexes = newQueryIndexes()
+	e = &running
+	defer e.indexes.collect(ch)
+	defer func() {
+		var collectors []string
+		for _, c := range collectorNames() {
+			if e.enabled(c) {
+				collectors = append(collectors, c)
+			}
+		}
+		e.success.update(time.Now(), collectors, e.failures, e.unrestricted())
+		e.success.collect(ch)
+		collectResults(ch, collectors, e.failures)
+	}()
+
+	ctx := e.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if e.scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.scrapeTimeout)
+		defer cancel()
+	}
+	ctx, span := e.tracer.start(ctx, "collect")
+	defer span.finish(nil)
+
+	// How many peers are in the Consul cluster? We'll use peers to decide
+	// that we're up. A failure doesn't hide the metrics of the other
+	// collectors, which may still succeed.
+	var peers []string
+	err := e.rawQuery(ctx, "/v1/status/peers", &peers)
+	e.readiness.set(err)
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(
+			up, prometheus.GaugeValue, 0,
+		)
+		e.queryError("/v1/status/peers", "", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(
+			up, prometheus.GaugeValue, 1,
+		)
+	}
+
+	// Only full collections are recorded, filtered scrapes see a partial
+	// state.
+	if e.snapshots != nil && e.unrestricted() {
+		recording := *e
+		recording.snapshot = newSnapshot(peers)
+		e = &recording
+		defer e.snapshots.store(e.snapshot)
+	}
+
+	var (
+		names    []string
+		mtx      sync.Mutex
+		finished = map[string]bool{}
+	)
+	for _, name := range collectorNames() {
+		if e.enabled(name) {
+			names = append(names, name)
+		}
+	}
+
+	// Collectors send their metrics through metrics, so that they can be
+	// abandoned when the deadline of the scrape passes while they keep
+	// running.
+	s := newScrape(ctx, e, peers)
+	s.peersErr = err
+	metrics := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.pool.each(len(names), func(i int) {
+			collectorRegistry[names[i]].collect(s, metrics)
+			mtx.Lock()
+			finished[names[i]] = true
+			mtx.Unlock()
+		})
+		s.finish()
+		s.collectPasses(metrics)
+	}()
+
+	for {
+		select {
+		case m := <-metrics:
+			ch <- m
+		case <-done:
+			ch <- prometheus.MustNewConstMetric(scrapeTimedOut, prometheus.GaugeValue, 0)
+			return
+		case <-ctx.Done():
+			go func() {
+				for {
+					select {
+					case <-metrics:
+					case <-done:
+						return
+					}
+				}
+			}()
+
+			mtx.Lock()
+			var abandoned []string
+			for _, name := range names {
+				if !finished[name] {
+					abandoned = append(abandoned, name)
+					e.failures.addCollector(name)
+				}
+			}
+			mtx.Unlock()
+			if ctx.Err() == context.DeadlineExceeded {
+				logger.Warn("Scrape timed out, abandoning collectors", "collectors", strings.Join(abandoned, ","), "timeout", e.scrapeTimeout)
+				ch <- prometheus.MustNewConstMetric(scrapeTimedOut, prometheus.GaugeValue, 1)
+			}
+			return
+		}
+	}
+}
+
+// queryError counts and logs a failed query of the Consul API, so that
+// partial collection failures are alertable. args are additional fields of
+// the log record, like the service.
+func (e *Exporter) queryError(endpoint, dc string, err error, args ...interface{}) {
+	e.failures.add(endpoint)
+	e.logQueryError(endpoint, dc, err, args...)
+}
+
+// collectorError is like queryError for queries whose results only the
+// collector relies on, so that only it is marked as failed and not the
+// collectors otherwise relying on the endpoint.
+func (e *Exporter) collectorError(collector, endpoint, dc string, err error, args ...interface{}) {
+	e.failures.addCollector(collector)
+	e.logQueryError(endpoint, dc, err, args...)
+}
+
+func (e *Exporter) logQueryError(endpoint, dc string, err error, args ...interface{}) {
+	if errors.Is(err, context.Canceled) {
+		// The scrape was given up, Consul is not to blame.
+		logger.Debug("Query canceled", append([]interface{}{"endpoint", endpoint, "datacenter", dc}, args...)...)
+		return
+	}
+	queryErrors.WithLabelValues(endpoint, dc).Inc()
+	classifiedErrors.WithLabelValues(endpoint, errorClass(err)).Inc()
+	if dc != "" {
+		e.stats.failed(dc, endpoint, err)
+	}
+	args = append([]interface{}{"endpoint", endpoint, "datacenter", dc, "err", err}, args...)
+	if isACLDenied(err) {
+		aclDenied.WithLabelValues(endpoint).Inc()
+		logger.Error("Permission denied by Consul ACLs, check the token's policies", args...)
+		return
+	}
+	logger.Error("Can't query consul", args...)
+}
+
+// observe exports the duration of a collector run that started at start.
+func (e *Exporter) observe(ch chan<- prometheus.Metric, collector, dc string, start time.Time) {
+	d := time.Since(start)
+	ch <- prometheus.MustNewConstMetric(
+		collectorDuration, prometheus.GaugeValue, d.Seconds(), collector, dc,
+	)
+	e.stats.observe(collector, dc, d)
+}
+
+// queryOptions returns the query options for the given datacenter and
+// endpoint, taking the per-datacenter overrides of the configuration file and
+// the per-endpoint consistency into account. Queries are bound to ctx and each
+// times out after the datacenter's timeout once the rate limiter let it
+// through. The cancel function must be called once the queries are done.
+func (e *Exporter) queryOptions(ctx context.Context, dc, endpoint string) (*consul_api.QueryOptions, context.CancelFunc) {
+	opts, timeout := e.baseQueryOptions(dc, endpoint)
+	ctx, cancel := context.WithCancel(withQueryTimeout(ctx, timeout))
+	return opts.WithContext(ctx), cancel
+}
+
+// rawQuery queries an endpoint for which the API client takes no query
+// options, like /v1/status/peers, bound to ctx and timing out like the queries
+// of queryOptions.
+func (e *Exporter) rawQuery(ctx context.Context, endpoint string, out interface{}) error {
+	opts := (&consul_api.QueryOptions{}).WithContext(withQueryTimeout(ctx, e.timeout))
+	_, err := e.client.Raw().Query(endpoint, out, opts)
+	return err
+}
+
+// baseQueryOptions returns the query options for the given datacenter and
+// endpoint along with the timeout of its queries.
+func (e *Exporter) baseQueryOptions(dc, endpoint string) (consul_api.QueryOptions, time.Duration) {
+	opts := e.baseOptions
+	opts.Datacenter = dc
+
+	timeout := e.timeout
+	if dcc, ok := e.datacenters[dc]; ok {
+		if dcc.AllowStale != nil {
+			opts.AllowStale = *dcc.AllowStale
+		}
+		if dcc.RequireConsistent != nil {
+			opts.RequireConsistent = *dcc.RequireConsistent
+		}
+		if dcc.timeout > 0 {
+			timeout = dcc.timeout
+		}
+	}
+	switch e.consistency[endpoint] {
+	case ConsistencyStale:
+		opts.AllowStale, opts.RequireConsistent = true, false
+	case ConsistencyDefault:
+		opts.AllowStale, opts.RequireConsistent = false, false
+	case ConsistencyConsistent:
+		opts.AllowStale, opts.RequireConsistent = false, true
+	}
+	// The agent rejects consistent reads of its cache.
+	if opts.RequireConsistent {
+		opts.UseCache, opts.MaxAge = false, 0
+	}
+	return opts, timeout
+}
+
+// withFilter returns a copy of opts with the given filter expression, which
+// Consul evaluates server-side to reduce the payload.
+func withFilter(opts *consul_api.QueryOptions, filter string) *consul_api.QueryOptions {
+	if filter == "" {
+		return opts
+	}
+	filtered := *opts
+	filtered.Filter = filter
+	return &filtered
+}
+
+// MetricsHandler returns the handler of the metrics path. It serves the
+// metrics of the gatherer g, typically prometheus.DefaultGatherer, along with
+// those of the exporter, which must not be registered with g. The exporter
+// collects with the context of the request, so that queries are canceled when
+// the scraper gives up. The collect[] and dc query parameters select a subset
+// of the exporter's collectors and datacenters. With background collection
+// or a minimum collection interval, other requests are served from the cache.
+func (e *Exporter) MetricsHandler(g prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if e.cache != nil && len(query["collect[]"]) == 0 && len(query["dc"]) == 0 && query.Get("kv.prefix") == "" {
+			serveMetrics(w, r, prometheus.Gatherers{g, e.cache}, e.metricsFilter)
+			return
+		}
+
+		scoped := e.withContext(r.Context())
+		if collect := query["collect[]"]; len(collect) > 0 {
+			var err error
+			if scoped, err = scoped.withCollectors(collect); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if dcs := query["dc"]; len(dcs) > 0 {
+			// Unknown datacenters would add series and state for
+			// arbitrary values.
+			known, err := e.knownDatacenters(r.Context())
+			if err != nil {
+				http.Error(w, "Can't query consul: "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			if dc, ok := unknownDatacenter(dcs, known); ok {
+				http.Error(w, fmt.Sprintf("unknown datacenter %q", dc), http.StatusBadRequest)
+				return
+			}
+			scoped = scoped.withDatacenters(dcs)
+		}
+		if prefix := query.Get("kv.prefix"); prefix != "" {
+			var err error
+			if scoped, err = scoped.withKVPrefix(prefix); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(scoped)
+		serveMetrics(w, r, prometheus.Gatherers{g, registry}, e.metricsFilter)
+	})
+}
+
+// serveMetrics writes the metrics of the gatherer allowed by the filter in the
+// negotiated exposition format.
+func serveMetrics(w http.ResponseWriter, r *http.Request, g prometheus.Gatherer, filter *metricsFilter) {
+	mfs, err := g.Gather()
+	if err != nil {
+		http.Error(w, "An error has occurred during metrics collection:\n\n"+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	contentType := expfmt.Negotiate(r.Header)
+	w.Header().Set("Content-Type", string(contentType))
+	enc := expfmt.NewEncoder(w, contentType)
+	for _, mf := range mfs {
+		if !filter.allowed(mf.GetName()) {
+			continue
+		}
+		if err := enc.Encode(mf); err != nil {
+			logger.Error("Error encoding metric family", "metric", mf.GetName(), "err", err)
+			return
+		}
+	}
+}
+
+// SnapshotHandler returns a handler serving the cluster state seen by the
+// last full collection as JSON.
+func (e *Exporter) SnapshotHandler() http.Handler {
+	return e.snapshots
+}
+
+// ReadinessHandler returns a handler responding with 200 if Consul was
+// reachable during the last collection and 503 otherwise.
+func (e *Exporter) ReadinessHandler() http.Handler {
+	return e.readiness
+}
+
+// Ready returns nil if Consul was reachable during the last collection.
+func (e *Exporter) Ready() error {
+	return e.readiness.ready()
+}
+
+// SDHandler returns a handler serving the service instances of the catalog
+// for Prometheus' HTTP service discovery.
+func (e *Exporter) SDHandler() http.Handler {
+	return http.HandlerFunc(e.serveSDTargets)
+}
+
+// StatusHandler returns a handler serving the details of the last collection
+// of each datacenter.
+func (e *Exporter) StatusHandler() http.Handler {
+	return http.HandlerFunc(e.serveStatus)
+}
+
+// EnableTracing traces the collections and sends the spans to the OTLP/HTTP
+// receiver at endpoint, e.g. http://localhost:4318. It must be called before
+// the first collection.
+func (e *Exporter) EnableTracing(endpoint string) {
+	e.tracer = newTracer(endpoint)
+}
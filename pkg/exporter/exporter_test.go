@@ -0,0 +1,303 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		uri string
+		ok  bool
+	}{
+		{uri: "", ok: false},
+		{uri: "localhost:8500", ok: true},
+		{uri: "https://localhost:8500", ok: true},
+		{uri: "http://some.where:8500", ok: true},
+		{uri: "fuuuu://localhost:8500", ok: false},
+	}
+
+	for _, test := range cases {
+		_, err := New(ConsulOpts{URI: test.uri}, WithHealthSummary())
+		if test.ok && err != nil {
+			t.Errorf("expected no error w/ %q, but got %q", test.uri, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("expected error w/ %q, but got %q", test.uri, err)
+		}
+	}
+}
+
+func TestTruncateServices(t *testing.T) {
+	services := map[string][]string{
+		"web":    {"prod"},
+		"api":    nil,
+		"consul": nil,
+		"db":     {"primary"},
+	}
+
+	truncated := truncateServices(services, 2)
+	if len(truncated) != 2 {
+		t.Fatalf("expected 2 services, got %d", len(truncated))
+	}
+	for _, name := range []string{"api", "consul"} {
+		if _, ok := truncated[name]; !ok {
+			t.Errorf("expected %q to be kept, got %v", name, truncated)
+		}
+	}
+}
+
+func TestKindFilter(t *testing.T) {
+	cases := []struct {
+		include, exclude []string
+		filter           string
+	}{
+		{filter: ""},
+		{exclude: []string{"connect-proxy"}, filter: `ServiceKind != "connect-proxy"`},
+		{include: []string{"typical", "mesh-gateway"}, filter: `(ServiceKind == "" or ServiceKind == "mesh-gateway")`},
+		{include: []string{"typical"}, exclude: []string{"connect-proxy"}, filter: `(ServiceKind == "") and ServiceKind != "connect-proxy"`},
+	}
+
+	for _, test := range cases {
+		if filter := kindFilter(test.include, test.exclude); filter != test.filter {
+			t.Errorf("expected filter %q, got %q", test.filter, filter)
+		}
+	}
+}
+
+func TestWithCollectors(t *testing.T) {
+	e, err := New(ConsulOpts{URI: "localhost:8500"}, WithHealthSummary())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filtered, err := e.withCollectors([]string{"kv", "health"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{"kv": true, "health": true, "raft": false, "catalog": false} {
+		if filtered.enabled(name) != expected {
+			t.Errorf("expected collector %q enabled to be %t", name, expected)
+		}
+		if !e.enabled(name) {
+			t.Errorf("expected collector %q to be enabled on the original exporter", name)
+		}
+	}
+
+	if _, err := e.withCollectors([]string{"fuuuu"}); err == nil {
+		t.Errorf("expected error for unknown collector")
+	}
+	if e.enabled("agent") {
+		t.Errorf("expected collector \"agent\" to be disabled by default")
+	}
+	if _, err := e.withCollectors([]string{"agent"}); err == nil {
+		t.Errorf("expected error for disabled collector")
+	}
+	if err := e.SetCollectors([]string{"agent"}); err != nil {
+		t.Fatal(err)
+	}
+	if !e.enabled("agent") || e.enabled("kv") {
+		t.Errorf("expected only collector \"agent\" to be enabled")
+	}
+}
+
+func TestTagsLabel(t *testing.T) {
+	cases := []struct {
+		tags  []string
+		label string
+	}{
+		{tags: nil, label: ",,"},
+		{tags: []string{"prod"}, label: ",prod,"},
+		{tags: []string{"v2", "prod", "canary"}, label: ",canary,prod,v2,"},
+		{tags: []string{"prod", "v2", "prod"}, label: ",prod,v2,"},
+	}
+
+	for _, test := range cases {
+		if label := tagsLabel(test.tags); label != test.label {
+			t.Errorf("expected %q for %v, got %q", test.label, test.tags, label)
+		}
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	e, err := New(ConsulOpts{URI: "localhost:8500"},
+		WithMaxServices(10),
+		WithFilters("", `Service == "web"`, ""),
+		WithServiceKinds(nil, []string{"connect-proxy"}),
+		WithChecksExclude("^serfHealth$"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.healthSummary {
+		t.Errorf("expected health summary to be disabled by default")
+	}
+	if e.maxServices != 10 {
+		t.Errorf("expected max services 10, got %d", e.maxServices)
+	}
+	if !e.filterKinds || e.servicesFilter == `Service == "web"` {
+		t.Errorf("expected kind filter to be added to services filter, got %q", e.servicesFilter)
+	}
+	if e.checksExclude == nil {
+		t.Errorf("expected checks exclude regex to be set")
+	}
+
+	if _, err := New(ConsulOpts{URI: "localhost:8500"}, WithKVWatch(), WithKVTxn()); err == nil {
+		t.Errorf("expected error for KV watch combined with transactions")
+	}
+	if _, err := New(ConsulOpts{URI: "localhost:8500"}, WithMeta([]string{"a-b", "a_b"}, nil)); err == nil {
+		t.Errorf("expected error for metadata keys mapping to the same label")
+	}
+	if _, err := New(ConsulOpts{URI: "localhost:8500"}, WithMeta(nil, []string{"team", "team"})); err == nil {
+		t.Errorf("expected error for repeated metadata keys")
+	}
+}
+
+func TestQueryOptionsContext(t *testing.T) {
+	e, err := New(ConsulOpts{URI: "localhost:8500"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scoped := e.withContext(ctx)
+	if e.ctx != nil {
+		t.Errorf("expected original exporter to keep its context")
+	}
+	opts, done := scoped.queryOptions(scoped.ctx, "dc1", EndpointHealth)
+	defer done()
+	cancel()
+	if opts.Context().Err() != context.Canceled {
+		t.Errorf("expected query context to be canceled with the scrape, got %v", opts.Context().Err())
+	}
+}
+
+func TestAgentCacheOptions(t *testing.T) {
+	e, err := New(ConsulOpts{
+		URI:         "localhost:8500",
+		AllowStale:  true,
+		Consistency: map[string]string{EndpointHealth: ConsistencyConsistent},
+	}, WithAgentCache(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, _ := e.baseQueryOptions("dc1", EndpointCatalog)
+	if !opts.UseCache || opts.MaxAge != time.Minute {
+		t.Errorf("expected cached catalog reads with max age 1m, got %v %s", opts.UseCache, opts.MaxAge)
+	}
+	opts, _ = e.baseQueryOptions("dc1", EndpointHealth)
+	if opts.UseCache {
+		t.Errorf("expected consistent health reads to bypass the agent cache")
+	}
+}
+
+func BenchmarkTagsLabel(b *testing.B) {
+	tags := []string{"canary", "prod", "v2"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tagsLabel(tags)
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/status/peers" {
+			w.Write([]byte(`["10.0.0.1:8300"]`))
+			return
+		}
+		<-release
+		http.Error(w, "released", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	e, err := New(ConsulOpts{URI: server.URL}, WithScrapeTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected the collection to be abandoned after the timeout, took %s", d)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "consul_exporter_scrape_timeout" {
+			if v := mf.Metric[0].GetGauge().GetValue(); v != 1 {
+				t.Errorf("expected consul_exporter_scrape_timeout 1, got %v", v)
+			}
+			return
+		}
+	}
+	t.Error("expected consul_exporter_scrape_timeout to be exported")
+}
+
+func TestWithKVPrefix(t *testing.T) {
+	e, err := New(ConsulOpts{URI: "http://localhost:1"}, WithKVPrefix("teams/", ".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped, err := e.withKVPrefix("teams/payments/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scoped.kvConfigs) != 1 || scoped.kvConfigs[0].prefix != "teams/payments/" || scoped.unrestricted() {
+		t.Errorf("expected a restricted scrape of teams/payments/, got %+v", scoped.kvConfigs)
+	}
+	if e.kvConfigs[0].prefix != "teams/" {
+		t.Errorf("expected the exporter's prefix to be unchanged, got %q", e.kvConfigs[0].prefix)
+	}
+	if _, err := e.withKVPrefix("secrets/"); err == nil {
+		t.Error("expected a prefix outside of teams/ to be rejected")
+	}
+}
+
+func TestScrapeTimeoutHangingStatus(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		http.Error(w, "released", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	e, err := New(ConsulOpts{URI: server.URL}, WithScrapeTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected the status queries to be abandoned after the timeout, took %s", d)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "consul_up" {
+			if v := mf.Metric[0].GetGauge().GetValue(); v != 0 {
+				t.Errorf("expected consul_up 0, got %v", v)
+			}
+			return
+		}
+	}
+	t.Error("expected consul_up to be exported")
+}
@@ -0,0 +1,203 @@
+package exporter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// filterKeywords are the reserved words of Consul's filter expressions.
+var filterKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true, "is": true,
+	"empty": true, "contains": true, "matches": true,
+}
+
+// filterToken is a token of a filter expression and its offset.
+type filterToken struct {
+	text string
+	pos  int
+}
+
+// filterParser checks the syntax of a filter expression. It doesn't know the
+// fields of the filtered types, Consul still rejects unknown selectors.
+type filterParser struct {
+	tokens []filterToken
+	i      int
+	end    int
+}
+
+// ValidateFilter checks the syntax of a filter expression as evaluated by
+// Consul, so that typos are reported at startup instead of failing every
+// query.
+func ValidateFilter(expr string) error {
+	if strings.TrimSpace(expr) == "" {
+		return nil
+	}
+	tokens, err := tokenizeFilter(expr)
+	if err == nil {
+		p := &filterParser{tokens: tokens, end: len(expr)}
+		if err = p.or(); err == nil && p.i < len(p.tokens) {
+			err = p.errorf("unexpected %q", p.tokens[p.i].text)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid filter %q: %s", expr, err)
+	}
+	return nil
+}
+
+// tokenizeFilter splits a filter expression into parentheses, brackets,
+// operators, quoted strings and bare words.
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			tokens = append(tokens, filterToken{expr[i : i+1], i})
+			i++
+		case strings.HasPrefix(expr[i:], "==") || strings.HasPrefix(expr[i:], "!="):
+			tokens = append(tokens, filterToken{expr[i : i+2], i})
+			i += 2
+		case c == '"' || c == '`':
+			j := i + 1
+			for j < len(expr) && expr[j] != c {
+				if c == '"' && expr[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			if _, err := strconv.Unquote(expr[i : j+1]); err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d", i)
+			}
+			tokens = append(tokens, filterToken{expr[i : j+1], i})
+			i = j + 1
+		default:
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t\n\r()[]\"`=!", rune(expr[j])) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected %q at offset %d", expr[i:i+1], i)
+			}
+			tokens = append(tokens, filterToken{expr[i:j], i})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	pos := p.end
+	if p.i < len(p.tokens) {
+		pos = p.tokens[p.i].pos
+	}
+	return fmt.Errorf(format+" at offset %d", append(args, pos)...)
+}
+
+func (p *filterParser) peek() string {
+	if p.i < len(p.tokens) {
+		return p.tokens[p.i].text
+	}
+	return ""
+}
+
+// accept consumes the next token if it is text.
+func (p *filterParser) accept(text string) bool {
+	if p.peek() == text {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) or() error {
+	for {
+		if err := p.and(); err != nil {
+			return err
+		}
+		if !p.accept("or") {
+			return nil
+		}
+	}
+}
+
+func (p *filterParser) and() error {
+	for {
+		if err := p.not(); err != nil {
+			return err
+		}
+		if !p.accept("and") {
+			return nil
+		}
+	}
+}
+
+func (p *filterParser) not() error {
+	if p.accept("not") {
+		return p.not()
+	}
+	if p.accept("(") {
+		if err := p.or(); err != nil {
+			return err
+		}
+		if !p.accept(")") {
+			return p.errorf("expected \")\"")
+		}
+		return nil
+	}
+	return p.match()
+}
+
+// match checks a single comparison like `Service == "web"`,
+// `"prod" in ServiceTags` or `Meta.env is not empty`.
+func (p *filterParser) match() error {
+	if err := p.operand(); err != nil {
+		return err
+	}
+	switch {
+	case p.accept("==") || p.accept("!=") || p.accept("contains") || p.accept("matches"):
+		return p.operand()
+	case p.accept("is"):
+		p.accept("not")
+		if !p.accept("empty") {
+			return p.errorf("expected \"empty\"")
+		}
+		return nil
+	case p.accept("in"):
+		return p.operand()
+	case p.accept("not"):
+		if p.accept("in") || p.accept("contains") || p.accept("matches") {
+			return p.operand()
+		}
+		return p.errorf("expected \"in\", \"contains\" or \"matches\" after \"not\"")
+	}
+	return p.errorf("expected operator")
+}
+
+// operand checks a selector or a value. Selectors may index maps with
+// ["key"].
+func (p *filterParser) operand() error {
+	text := p.peek()
+	if text == "" || filterKeywords[text] || strings.ContainsAny(text[:1], "()[]=!") {
+		if text == "" {
+			return p.errorf("expected selector or value")
+		}
+		return p.errorf("expected selector or value, got %q", text)
+	}
+	p.i++
+	for p.accept("[") {
+		if key := p.peek(); key == "" || (key[0] != '"' && key[0] != '`') {
+			return p.errorf("expected quoted key")
+		}
+		p.i++
+		if !p.accept("]") {
+			return p.errorf("expected \"]\"")
+		}
+	}
+	return nil
+}
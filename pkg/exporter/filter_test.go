@@ -0,0 +1,39 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFilter(t *testing.T) {
+	valid := []string{
+		"",
+		`Service == "web"`,
+		`ServiceKind != "" and not (Meta["env"] == prod or "canary" in ServiceTags)`,
+		`Meta.env is not empty`,
+		"Node matches `^web-[0-9]+$`",
+		`"canary" not in ServiceTags or ServiceTags contains "prod"`,
+		kindFilter([]string{"typical", "mesh-gateway"}, []string{"connect-proxy"}),
+	}
+	for _, expr := range valid {
+		if err := ValidateFilter(expr); err != nil {
+			t.Errorf("expected %q to be valid, got %s", expr, err)
+		}
+	}
+
+	invalid := map[string]string{
+		`Service = "web"`:            `unexpected "="`,
+		`Service == "web`:            "unterminated string at offset 11",
+		`(Service == "web"`:          `expected ")" at offset 17`,
+		`Service == "web" and`:       "expected selector or value at offset 20",
+		`Service "web"`:              "expected operator at offset 8",
+		`Meta.env is`:                `expected "empty" at offset 11`,
+		`Service == "web" Node == a`: `unexpected "Node" at offset 17`,
+	}
+	for expr, want := range invalid {
+		err := ValidateFilter(expr)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error containing %q for %q, got %v", want, expr, err)
+		}
+	}
+}
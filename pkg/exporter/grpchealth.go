@@ -0,0 +1,35 @@
+package exporter
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// RegisterHealthServer registers the grpc.health.v1 Health service, which
+// reports the exporter as serving if Consul was reachable during the last
+// collection.
+func (e *Exporter) RegisterHealthServer(s *grpc.Server) {
+	healthpb.RegisterHealthServer(s, grpcHealth{readiness: e.readiness})
+}
+
+// grpcHealth implements the grpc.health.v1 Health service on top of the
+// readiness of the exporter. The exporter is the service with the empty name.
+type grpcHealth struct {
+	readiness *readiness
+}
+
+// Check reports the exporter as serving if Consul was reachable during the
+// last collection, and as not serving otherwise or before the first one.
+func (h grpcHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if req.Service != "" {
+		return nil, grpc.Errorf(codes.NotFound, "unknown service %q", req.Service)
+	}
+	status := healthpb.HealthCheckResponse_SERVING
+	if h.readiness.ready() != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return &healthpb.HealthCheckResponse{Status: status}, nil
+}
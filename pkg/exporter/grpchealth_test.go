@@ -0,0 +1,38 @@
+package exporter
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestGRPCHealthCheck(t *testing.T) {
+	r := newReadiness()
+	h := grpcHealth{readiness: r}
+	for _, tc := range []struct {
+		err    error
+		status healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{errNotCollected, healthpb.HealthCheckResponse_NOT_SERVING},
+		{nil, healthpb.HealthCheckResponse_SERVING},
+		{errors.New("connection refused"), healthpb.HealthCheckResponse_NOT_SERVING},
+	} {
+		r.set(tc.err)
+		resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != tc.status {
+			t.Errorf("expected %v with %v, got %v", tc.status, tc.err, resp.Status)
+		}
+	}
+
+	if _, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "web"}); grpc.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for an unknown service, got %v", err)
+	}
+}
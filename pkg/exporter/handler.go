@@ -0,0 +1,130 @@
+package exporter
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+)
+
+// HandlerConfig configures the handler returned by Handler.
+type HandlerConfig struct {
+	Exporter *Exporter
+
+	// MetricsPath is the path of the metrics, /metrics by default.
+	MetricsPath string
+	// Gatherer gathers the metrics served along with the exporter's,
+	// prometheus.DefaultGatherer by default.
+	Gatherer prometheus.Gatherer
+	// MetricsMiddleware, if set, wraps the handler of the metrics path, e.g.
+	// to authenticate or log scrapes.
+	MetricsMiddleware func(http.Handler) http.Handler
+	// Namespace is the prefix of the metric names in the alerting rules
+	// served at /rules and the dashboard served at /dashboard, Namespace by
+	// default.
+	Namespace string
+	// Overview serves the HTML overview of the last collection at
+	// /overview.
+	Overview bool
+	// Config, if set, is the effective configuration served as JSON at
+	// /config. Secrets must be redacted.
+	Config []byte
+}
+
+// Handler returns a handler serving the metrics, the landing page, the
+// health endpoints /-/healthy and /-/ready, the alerting rules at /rules, the
+// Grafana dashboard at /dashboard, the effective configuration at /config and
+// the auxiliary endpoints of the exporter. Links between them are relative,
+// so that it can be mounted below a prefix with http.StripPrefix.
+func Handler(cfg HandlerConfig) http.Handler {
+	e := cfg.Exporter
+	if cfg.MetricsPath == "" {
+		cfg.MetricsPath = "/metrics"
+	}
+	if cfg.Gatherer == nil {
+		cfg.Gatherer = prometheus.DefaultGatherer
+	}
+
+	metrics := e.MetricsHandler(cfg.Gatherer)
+	if cfg.MetricsMiddleware != nil {
+		metrics = cfg.MetricsMiddleware(metrics)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(cfg.MetricsPath, metrics)
+	mux.Handle("/api/v1/snapshot", e.SnapshotHandler())
+	mux.Handle("/status", e.StatusHandler())
+	mux.Handle("/sd/targets", e.SDHandler())
+	mux.Handle("/rules", rulesHandler(cfg.Namespace))
+	mux.Handle("/dashboard", dashboardHandler(e, cfg.Namespace))
+	if cfg.Overview {
+		mux.Handle("/overview", e.OverviewHandler())
+	}
+	if cfg.Config != nil {
+		mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(cfg.Config)
+		})
+	}
+	mux.Handle("/-/ready", e.ReadinessHandler())
+	mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Healthy.\n"))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		e.serveLandingPage(w, cfg.MetricsPath, cfg.Overview, cfg.Config != nil)
+	})
+	return mux
+}
+
+var landingPageTemplate = template.Must(template.New("landing").Parse(`<html>
+<head><title>Consul Exporter</title></head>
+<body>
+<h1>Consul Exporter</h1>
+<p><a href="{{.MetricsPath}}">Metrics</a></p>
+<p><a href="api/v1/snapshot">Snapshot</a></p>
+<p><a href="status">Status</a></p>
+{{if .Overview}}<p><a href="overview">Overview</a></p>{{end}}
+{{if .Config}}<p><a href="config">Configuration</a></p>{{end}}
+<p><a href="rules">Alerting rules</a></p>
+<p><a href="dashboard">Grafana dashboard</a></p>
+<h2>Options</h2>
+<pre>{{.Options}}</pre>
+<h2>Build</h2>
+<pre>{{.Version}} {{.BuildContext}}</pre>
+</body>
+</html>
+`))
+
+// serveLandingPage writes the landing page linking the metrics path and, if
+// served, the overview and the configuration.
+func (e *Exporter) serveLandingPage(w http.ResponseWriter, metricsPath string, overview, config bool) {
+	options, err := json.Marshal(struct{ AllowStale, RequireConsistent bool }{
+		e.baseOptions.AllowStale, e.baseOptions.RequireConsistent,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := landingPageTemplate.Execute(w, struct {
+		MetricsPath, Options, Version, BuildContext string
+		Overview, Config                            bool
+	}{
+		MetricsPath:  strings.TrimPrefix(metricsPath, "/"),
+		Overview:     overview,
+		Config:       config,
+		Options:      string(options),
+		Version:      version.Info(),
+		BuildContext: version.BuildContext(),
+	}); err != nil {
+		logger.Error("Error rendering landing page", "err", err)
+	}
+}
@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHandler(t *testing.T) {
+	e, err := New(ConsulOpts{URI: "localhost:8500"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.StripPrefix("/consul", Handler(HandlerConfig{Exporter: e, MetricsPath: "/scrape"}))
+
+	for path, code := range map[string]int{
+		"/consul/":          http.StatusOK,
+		"/consul/-/healthy": http.StatusOK,
+		"/consul/-/ready":   http.StatusServiceUnavailable,
+		"/consul/unknown":   http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code {
+			t.Errorf("%s: expected status %d, got %d", path, code, w.Code)
+		}
+		if path == "/consul/" && !strings.Contains(w.Body.String(), `href="scrape"`) {
+			t.Errorf("expected relative link to the metrics path, got %s", w.Body.String())
+		}
+	}
+}
+
+func TestMetricsHandlerDatacenters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/catalog/datacenters":
+			w.Write([]byte(`["dc1", "dc2"]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	e, err := New(ConsulOpts{URI: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := e.MetricsHandler(prometheus.NewRegistry())
+	for query, code := range map[string]int{
+		"?dc=dc2":         http.StatusOK,
+		"?dc=dc1&dc=dc2":  http.StatusOK,
+		"?dc=made-up":     http.StatusBadRequest,
+		"?dc=dc1&dc=dc3":  http.StatusBadRequest,
+		"?collect[]=raft": http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics"+query, nil))
+		if w.Code != code {
+			t.Errorf("%s: expected status %d, got %d: %s", query, code, w.Code, w.Body.String())
+		}
+	}
+}
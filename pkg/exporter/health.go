@@ -0,0 +1,406 @@
+package exporter
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+func init() {
+	registerCollector(collectorHealth, healthCollector{}, true)
+}
+
+// healthCollector collects the health checks of each datacenter and, if
+// enabled, the health summary of every service instance.
+type healthCollector struct{}
+
+func (healthCollector) collect(s *scrape, ch chan<- prometheus.Metric) {
+	e := s.e
+	s.forEachDatacenter(func(dc string) {
+		ctx, span := e.tracer.start(s.ctx, "collect health", "consul.datacenter", dc)
+		defer span.finish(nil)
+		defer s.observe(ch, collectorHealth, dc, time.Now())
+
+		services, err := s.catalogServices(dc)
+		if err != nil {
+			return
+		}
+		healthOptions, cancel := e.queryOptions(ctx, dc, EndpointHealth)
+		defer cancel()
+
+		// Service metadata is only returned by the per-service queries.
+		if e.healthSummary && e.serviceMeta != nil {
+			e.collectHealthSummary(ch, services.names, healthOptions)
+		}
+		if e.breakdownTags != nil {
+			e.collectTagBreakdown(ch, services.names, healthOptions)
+		}
+
+		checks, err := e.healthChecks(healthOptions, services.names)
+		if err != nil {
+			return
+		}
+		if e.healthSummary && e.serviceMeta == nil {
+			instances := e.uncheckedInstances(ctx, dc, services.names, checks)
+			e.collectHealthSummaryFromChecks(ch, dc, services.names, checks, instances)
+		}
+
+		collected := 0
+		var tracked consul_api.HealthChecks
+		for _, hc := range checks {
+			// Drop checks of services which aren't collected.
+			if (services.truncated || e.filterKinds || e.shard.enabled()) && hc.ServiceID != "" {
+				if _, ok := services.names[hc.ServiceName]; !ok {
+					continue
+				}
+			}
+			if hc.ServiceID == "" && !e.shard.owns(hc.Node) {
+				continue
+			}
+			if e.checksExclude != nil && e.checksExclude.MatchString(hc.CheckID) {
+				continue
+			}
+
+			e.snapshot.addCheck(dc, hc)
+			collected++
+			if e.checkStates != nil {
+				tracked = append(tracked, hc)
+			}
+			status := e.statusValue(hc.Status)
+
+			if hc.ServiceID == "" {
+				desc, labels := nodeChecks, []string{e.checkLabel(hc), hc.Name, hc.Node, hc.Status, dc}
+				if e.checkType {
+					desc, labels = nodeChecksTyped, append(labels, hc.Type)
+				}
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, status, labels...)
+			} else {
+				desc, labels := serviceChecks, []string{e.checkLabel(hc), hc.Name, hc.Node, hc.ServiceID, hc.ServiceName, hc.Status, dc, tagsLabel(hc.ServiceTags)}
+				if e.checkType {
+					desc, labels = serviceChecksTyped, append(labels, hc.Type)
+				}
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, status, labels...)
+			}
+			if hc.Notes != "" {
+				ch <- prometheus.MustNewConstMetric(
+					checkInfo, prometheus.GaugeValue, 1, e.checkLabel(hc), hc.Name, hc.Node, hc.ServiceID, dc, truncateNotes(hc.Notes),
+				)
+			}
+		}
+		e.stats.addChecks(dc, collected)
+
+		if e.checkStates != nil {
+			for i, d := range e.checkStates.update(dc, tracked, time.Now()) {
+				hc := tracked[i]
+				ch <- prometheus.MustNewConstMetric(
+					checkStateDuration, prometheus.GaugeValue, d.Seconds(), e.checkLabel(hc), hc.Node, hc.ServiceID, dc, hc.Status,
+				)
+				if remaining, ok := deregisterCountdown(hc, d); ok {
+					ch <- prometheus.MustNewConstMetric(
+						deregisterRemaining, prometheus.GaugeValue, remaining.Seconds(), e.checkLabel(hc), hc.Node, hc.ServiceID, dc,
+					)
+				}
+			}
+		}
+	})
+}
+
+// healthChecks returns the health checks of the datacenter of the query
+// options, from the watch cache if enabled. Per-service watches only return
+// the checks of the given services.
+func (e *Exporter) healthChecks(queryOptions *consul_api.QueryOptions, serviceNames map[string][]string) (consul_api.HealthChecks, error) {
+	if e.watches != nil {
+		dw, err := e.watches.datacenter(queryOptions.Context(), queryOptions.Datacenter)
+		if err != nil {
+			e.queryError("/v1/health/state", queryOptions.Datacenter, err)
+			return nil, err
+		}
+		v, err := e.watched(dw.checks)
+		checks, _ := v.(consul_api.HealthChecks)
+		if err != nil || dw.serviceHealth == nil {
+			return checks, err
+		}
+		serviceChecks, index, err := dw.serviceHealth.checks(serviceNames)
+		if err != nil {
+			e.queryError("/v1/health/service", queryOptions.Datacenter, err)
+			return nil, err
+		}
+		e.indexes.record("/v1/health/service", queryOptions.Datacenter, index)
+		return append(checks, serviceChecks...), nil
+	}
+
+	checks, meta, err := e.client.Health().State("any", withFilter(queryOptions, e.healthFilter))
+	if err != nil {
+		e.queryError("/v1/health/state", queryOptions.Datacenter, err)
+		return nil, err
+	}
+	e.indexes.record("/v1/health/state", queryOptions.Datacenter, meta.LastIndex)
+	return checks, nil
+}
+
+// uncheckedInstances returns the catalog instances of the services without any
+// service check, which aren't known to the health state. They are read from
+// the watches of these services, without catalog watches they are left out.
+// With a health filter, services without matching checks are filtered out
+// instead.
+func (e *Exporter) uncheckedInstances(ctx context.Context, dc string, serviceNames map[string][]string, checks consul_api.HealthChecks) []*consul_api.CatalogService {
+	if e.healthFilter != "" || e.watches == nil {
+		return nil
+	}
+	checked := map[string]bool{}
+	for _, hc := range checks {
+		if hc.ServiceID != "" {
+			checked[hc.ServiceName] = true
+		}
+	}
+	unchecked := map[string][]string{}
+	var names []string
+	for name, tags := range serviceNames {
+		if !checked[name] {
+			unchecked[name] = tags
+			names = append(names, name)
+		}
+	}
+
+	dw, err := e.watches.datacenter(ctx, dc)
+	if err != nil {
+		e.queryError("/v1/health/service", dc, err)
+		return nil
+	}
+	sw := dw.serviceHealth
+	if sw == nil {
+		sw = dw.unchecked
+		sw.sync(unchecked)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	entries, index, err := sw.entries(names)
+	if err == errWatchNotSynced {
+		// New services are left out until their watch has synced.
+		return nil
+	}
+	if err != nil {
+		e.queryError("/v1/health/service", dc, err)
+		return nil
+	}
+	e.indexes.record("/v1/health/service", dc, index)
+
+	instances := make([]*consul_api.CatalogService, 0, len(entries))
+	for _, entry := range entries {
+		instances = append(instances, &consul_api.CatalogService{
+			Node:        entry.Node.Node,
+			ServiceID:   entry.Service.ID,
+			ServiceName: entry.Service.Service,
+			ServiceTags: entry.Service.Tags,
+		})
+	}
+	return instances
+}
+
+// collectHealthSummaryFromChecks collects the health of every service instance
+// from the health checks of the datacenter, which are joined with the checks
+// of their node. Catalog instances without any checks of their own are merged
+// in, with the health of their node like the health endpoint of a service.
+func (e *Exporter) collectHealthSummaryFromChecks(ch chan<- prometheus.Metric, dc string, serviceNames map[string][]string, checks consul_api.HealthChecks, instances []*consul_api.CatalogService) {
+	type instance struct {
+		node, serviceID string
+	}
+	// Most checks belong to service instances, usually one per instance.
+	nodeChecks := map[string]consul_api.HealthChecks{}
+	instanceChecks := make(map[instance]consul_api.HealthChecks, len(checks))
+	for _, hc := range checks {
+		if hc.ServiceID == "" {
+			nodeChecks[hc.Node] = append(nodeChecks[hc.Node], hc)
+			continue
+		}
+		if _, ok := serviceNames[hc.ServiceName]; !ok {
+			continue
+		}
+		i := instance{hc.Node, hc.ServiceID}
+		instanceChecks[i] = append(instanceChecks[i], hc)
+	}
+
+	for i, hcs := range instanceChecks {
+		// Like the health endpoint of a service, a service instance
+		// is passing if all checks of the service and its node are.
+		status := e.statusValue(append(hcs, nodeChecks[i.node]...).AggregatedStatus())
+		ch <- prometheus.MustNewConstMetric(
+			serviceNodesHealthy, prometheus.GaugeValue, status, i.serviceID, i.node, hcs[0].ServiceName, dc, tagsLabel(hcs[0].ServiceTags),
+		)
+		e.collectServiceTags(ch, i.serviceID, i.node, hcs[0].ServiceName, dc, hcs[0].ServiceTags)
+	}
+	for _, cs := range instances {
+		if _, ok := serviceNames[cs.ServiceName]; !ok {
+			continue
+		}
+		if _, ok := instanceChecks[instance{cs.Node, cs.ServiceID}]; ok {
+			continue
+		}
+		status := e.statusValue(nodeChecks[cs.Node].AggregatedStatus())
+		ch <- prometheus.MustNewConstMetric(
+			serviceNodesHealthy, prometheus.GaugeValue, status, cs.ServiceID, cs.Node, cs.ServiceName, dc, tagsLabel(cs.ServiceTags),
+		)
+		e.collectServiceTags(ch, cs.ServiceID, cs.Node, cs.ServiceName, dc, cs.ServiceTags)
+	}
+}
+
+// collectHealthSummary collects health information about every node+service
+// combination. It will cause one lookup query per service.
+func (e *Exporter) collectHealthSummary(ch chan<- prometheus.Metric, serviceNames map[string][]string, queryOptions *consul_api.QueryOptions) {
+	names := make([]string, 0, len(serviceNames))
+	for s := range serviceNames {
+		names = append(names, s)
+	}
+	e.pool.each(len(names), func(i int) {
+		e.collectOneHealthSummary(ch, names[i], queryOptions)
+	})
+}
+
+func (e *Exporter) collectOneHealthSummary(ch chan<- prometheus.Metric, serviceName string, queryOptions *consul_api.QueryOptions) error {
+	if err := queryOptions.Context().Err(); err != nil {
+		return err
+	}
+	logger.Debug("Fetching health summary", "service", serviceName, "datacenter", queryOptions.Datacenter)
+
+	service, meta, err := e.client.Health().Service(serviceName, "", false, queryOptions)
+	if err != nil {
+		e.queryError("/v1/health/service", queryOptions.Datacenter, err, "service", serviceName)
+		return err
+	}
+	e.indexes.record("/v1/health/service", queryOptions.Datacenter, meta.LastIndex)
+
+	for _, entry := range service {
+		// We have a Node, a Service, and one or more Checks. Our
+		// service-node combo is passing if all checks have a `status`
+		// of "passing."
+		status := e.statusValue(entry.Checks.AggregatedStatus())
+		ch <- prometheus.MustNewConstMetric(
+			serviceNodesHealthy, prometheus.GaugeValue, status, entry.Service.ID, entry.Node.Node, entry.Service.Service, queryOptions.Datacenter, tagsLabel(entry.Service.Tags),
+		)
+		e.collectServiceTags(ch, entry.Service.ID, entry.Node.Node, entry.Service.Service, queryOptions.Datacenter, entry.Service.Tags)
+		if e.serviceMeta != nil {
+			ch <- prometheus.MustNewConstMetric(
+				e.serviceMeta, prometheus.GaugeValue, 1,
+				append([]string{entry.Service.ID, entry.Node.Node, entry.Service.Service, queryOptions.Datacenter}, metaLabelValues(e.serviceMetaKeys, entry.Service.Meta)...)...,
+			)
+		}
+	}
+	return nil
+}
+
+// checkLabel returns the value of the check label of a health check, its ID
+// or, with WithCheckLabel(CheckLabelName), its name.
+func (e *Exporter) checkLabel(hc *consul_api.HealthCheck) string {
+	if e.checkByName {
+		return hc.Name
+	}
+	return hc.CheckID
+}
+
+// maxNotesLength is the number of characters of check notes exported as a
+// label value.
+const maxNotesLength = 256
+
+// truncateNotes truncates check notes to maxNotesLength characters including
+// the trailing ellipsis, so that long free-form notes don't bloat the series.
+func truncateNotes(notes string) string {
+	if utf8.RuneCountInString(notes) <= maxNotesLength {
+		return notes
+	}
+	n := 0
+	for i := range notes {
+		if n == maxNotesLength-1 {
+			return notes[:i] + "…"
+		}
+		n++
+	}
+	return notes
+}
+
+// collectServiceTags collects a consul_service_tag series for every tag of a
+// service instance. Duplicate tags are only collected once.
+func (e *Exporter) collectServiceTags(ch chan<- prometheus.Metric, serviceID, node, serviceName, dc string, tags []string) {
+	for i, tag := range tags {
+		if duplicateTag(tags[:i], tag) {
+			continue
+		}
+		labels := []string{serviceID, node, tag}
+		if e.serviceTag == serviceTagLabeled {
+			labels = append(labels, serviceName, dc)
+		}
+		ch <- prometheus.MustNewConstMetric(e.serviceTag, prometheus.GaugeValue, 1, labels...)
+	}
+}
+
+// duplicateTag returns whether tag is one of tags. Services have few tags, so
+// a linear search beats a map.
+func duplicateTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// tagsLabel returns the value of the tags label. Tags are sorted and
+// deduplicated, so that the value doesn't depend on the registration order.
+// It is called for every health check, so tags which are already sorted and
+// unique, the common case, are neither copied nor sorted.
+func tagsLabel(tags []string) string {
+	switch len(tags) {
+	case 0:
+		return ",,"
+	case 1:
+		return "," + tags[0] + ","
+	}
+
+	if !sortedUnique(tags) {
+		sorted := make([]string, len(tags))
+		copy(sorted, tags)
+		sort.Strings(sorted)
+
+		unique := sorted[:0]
+		for i, tag := range sorted {
+			if i == 0 || tag != sorted[i-1] {
+				unique = append(unique, tag)
+			}
+		}
+		tags = unique
+	}
+
+	size := 1
+	for _, tag := range tags {
+		size += len(tag) + 1
+	}
+	var b strings.Builder
+	b.Grow(size)
+	b.WriteByte(',')
+	for _, tag := range tags {
+		b.WriteString(tag)
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+// sortedUnique returns whether the tags are sorted without duplicates.
+func sortedUnique(tags []string) bool {
+	for i := 1; i < len(tags); i++ {
+		if tags[i] <= tags[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// statusValue returns the numeric encoding of a health check state.
+func (e *Exporter) statusValue(status string) float64 {
+	return e.statusValues[status]
+}
@@ -0,0 +1,223 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	consul_api "github.com/hashicorp/consul/api"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollectHealthSummaryFromChecks(t *testing.T) {
+	e := &Exporter{statusValues: defaultStatusValues}
+	checks := consul_api.HealthChecks{
+		{Node: "n1", CheckID: "serfHealth", Status: consul_api.HealthPassing},
+		{Node: "n2", CheckID: "serfHealth", Status: consul_api.HealthCritical},
+		{Node: "n1", CheckID: "service:web-1", ServiceID: "web-1", ServiceName: "web", Status: consul_api.HealthPassing},
+		{Node: "n2", CheckID: "service:web-2", ServiceID: "web-2", ServiceName: "web", Status: consul_api.HealthPassing},
+		{Node: "n1", CheckID: "service:db-1", ServiceID: "db-1", ServiceName: "db", Status: consul_api.HealthPassing},
+	}
+	// Instances without checks of their own are only known to the catalog.
+	instances := []*consul_api.CatalogService{
+		{Node: "n1", ServiceID: "web-1", ServiceName: "web"},
+		{Node: "n1", ServiceID: "cache-1", ServiceName: "cache"},
+		{Node: "n2", ServiceID: "cache-2", ServiceName: "cache"},
+		{Node: "n3", ServiceID: "cache-3", ServiceName: "cache"},
+		{Node: "n1", ServiceID: "db-2", ServiceName: "db"},
+	}
+
+	ch := make(chan prometheus.Metric, 20)
+	e.collectHealthSummaryFromChecks(ch, "dc1", map[string][]string{"web": nil, "cache": nil}, checks, instances)
+	close(ch)
+
+	healthy := map[string]float64{}
+	series := 0
+	for m := range ch {
+		series++
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		for _, lp := range pb.Label {
+			if lp.GetName() == "service_id" {
+				healthy[lp.GetValue()] = pb.GetGauge().GetValue()
+			}
+		}
+	}
+	expected := map[string]float64{"web-1": 1, "web-2": 3, "cache-1": 1, "cache-2": 3, "cache-3": 1}
+	if series != len(expected) || len(healthy) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, healthy)
+	}
+	for id, v := range expected {
+		if healthy[id] != v {
+			t.Errorf("expected %s healthy %v, got %v", id, v, healthy[id])
+		}
+	}
+}
+
+func TestUncheckedInstancesWatched(t *testing.T) {
+	var catalogQueries int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/catalog/datacenters":
+			w.Write([]byte(`["dc1"]`))
+		case r.URL.Path == "/v1/health/service/cache":
+			if r.URL.Query().Get("index") != "" {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			w.Header().Set("X-Consul-Index", "4")
+			w.Write([]byte(`[{"Node": {"Node": "n1"}, "Service": {"ID": "cache-1", "Service": "cache"}, "Checks": [
+				{"Node": "n1", "CheckID": "serfHealth", "Status": "passing"}
+			]}]`))
+		case strings.HasPrefix(r.URL.Path, "/v1/catalog/service/"):
+			atomic.AddInt32(&catalogQueries, 1)
+			http.NotFound(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	e, err := New(ConsulOpts{URI: server.URL}, WithCatalogWatch())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.indexes = newQueryIndexes()
+	checks := consul_api.HealthChecks{
+		{Node: "n1", CheckID: "service:web-1", ServiceID: "web-1", ServiceName: "web", Status: consul_api.HealthPassing},
+	}
+	names := map[string][]string{"web": nil, "cache": nil}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		instances := e.uncheckedInstances(context.Background(), "dc1", names, checks)
+		if len(instances) > 0 {
+			if len(instances) != 1 || instances[0].ServiceID != "cache-1" || instances[0].Node != "n1" {
+				t.Errorf("expected cache-1 on n1, got %v", instances)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watch didn't sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&catalogQueries); n != 0 {
+		t.Errorf("expected no catalog queries per service, got %d", n)
+	}
+}
+
+func TestCollectServiceTags(t *testing.T) {
+	for _, tc := range []struct {
+		desc   *prometheus.Desc
+		labels int
+	}{
+		{serviceTag, 3},
+		{serviceTagLabeled, 5},
+	} {
+		e := &Exporter{serviceTag: tc.desc}
+		ch := make(chan prometheus.Metric, 10)
+		e.collectServiceTags(ch, "web-1", "n1", "web", "dc1", []string{"blue", "http", "blue"})
+		close(ch)
+
+		var tags []string
+		for m := range ch {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				t.Fatal(err)
+			}
+			if len(pb.Label) != tc.labels {
+				t.Errorf("expected %d labels, got %v", tc.labels, pb.Label)
+			}
+			for _, lp := range pb.Label {
+				if lp.GetName() == "tag" {
+					tags = append(tags, lp.GetValue())
+				}
+			}
+		}
+		if len(tags) != 2 || tags[0] != "blue" || tags[1] != "http" {
+			t.Errorf("expected tags [blue http], got %v", tags)
+		}
+	}
+}
+
+func TestTruncateNotes(t *testing.T) {
+	short := "See https://runbooks.example.com/web"
+	if got := truncateNotes(short); got != short {
+		t.Errorf("expected %q, got %q", short, got)
+	}
+	long := strings.Repeat("ä", maxNotesLength+10)
+	if got, want := truncateNotes(long), strings.Repeat("ä", maxNotesLength-1)+"…"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	exact := strings.Repeat("a", maxNotesLength)
+	if got := truncateNotes(exact); got != exact {
+		t.Errorf("expected notes of maximum length to be kept, got %q", got)
+	}
+	over := strings.Repeat("a", maxNotesLength+1)
+	if got := utf8.RuneCountInString(truncateNotes(over)); got != maxNotesLength {
+		t.Errorf("expected truncated notes of %d characters, got %d", maxNotesLength, got)
+	}
+}
+
+func TestCheckLabel(t *testing.T) {
+	hc := &consul_api.HealthCheck{CheckID: "_nomad-check-3c1f", Name: "web-http"}
+	if got := (&Exporter{}).checkLabel(hc); got != hc.CheckID {
+		t.Errorf("expected the check ID by default, got %q", got)
+	}
+	if got := (&Exporter{checkByName: true}).checkLabel(hc); got != hc.Name {
+		t.Errorf("expected the check name, got %q", got)
+	}
+}
+
+func TestServiceCheckLabels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/catalog/services":
+			w.Write([]byte(`{"web":[]}`))
+		case "/v1/health/state/any":
+			w.Write([]byte(`[{"Node":"n1","CheckID":"service:web","Name":"Service 'web' check","Status":"critical","ServiceID":"web","ServiceName":"web"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	e, err := New(ConsulOpts{URI: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newScrape(context.Background(), e.withDatacenters([]string{"dc1"}), nil)
+	ch := make(chan prometheus.Metric, 10)
+	healthCollector{}.collect(s, ch)
+	close(ch)
+
+	for m := range ch {
+		if m.Desc() != serviceChecks {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := map[string]string{}
+		for _, l := range pb.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if labels["status"] != "critical" || labels["datacenter"] != "dc1" {
+			t.Errorf("expected status critical and datacenter dc1, got %v", labels)
+		}
+		return
+	}
+	t.Error("expected consul_health_service_status to be exported")
+}
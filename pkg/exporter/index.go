@@ -0,0 +1,78 @@
+package exporter
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// queryIndexes records the highest Raft index returned by each endpoint and
+// datacenter during a single collection, and the lowest one per datacenter.
+// Its methods are safe to call on a nil value.
+type queryIndexes struct {
+	mtx     sync.Mutex
+	indexes map[[2]string]uint64
+	lowest  map[string]uint64
+}
+
+func newQueryIndexes() *queryIndexes {
+	return &queryIndexes{indexes: map[[2]string]uint64{}, lowest: map[string]uint64{}}
+}
+
+// record records the index of a response of the endpoint, zero meaning the
+// response didn't carry one.
+func (q *queryIndexes) record(endpoint, dc string, index uint64) {
+	if q == nil || index == 0 {
+		return
+	}
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	key := [2]string{endpoint, dc}
+	if index > q.indexes[key] {
+		q.indexes[key] = index
+	}
+	if lowest, ok := q.lowest[dc]; !ok || index < lowest {
+		q.lowest[dc] = index
+	}
+}
+
+// highest returns the highest index returned by any endpoint for the
+// datacenter.
+func (q *queryIndexes) highest(dc string) uint64 {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return q.highestLocked(dc)
+}
+
+func (q *queryIndexes) highestLocked(dc string) uint64 {
+	var highest uint64
+	for key, index := range q.indexes {
+		if key[1] == dc && index > highest {
+			highest = index
+		}
+	}
+	return highest
+}
+
+// collect exports the recorded indexes and, per datacenter, the spread
+// between the lowest and highest one. Consul can't answer queries as of a
+// given index, so responses of a collection may describe different states of
+// the cluster, the spread shows by how far. KV responses without datacenter
+// are skipped.
+func (q *queryIndexes) collect(ch chan<- prometheus.Metric) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	for key, index := range q.indexes {
+		ch <- prometheus.MustNewConstMetric(
+			catalogIndex, prometheus.GaugeValue, float64(index), key[0], key[1],
+		)
+	}
+	for dc, lowest := range q.lowest {
+		if dc == "" {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			indexSpread, prometheus.GaugeValue, float64(q.highestLocked(dc)-lowest), dc,
+		)
+	}
+}
@@ -0,0 +1,60 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestQueryIndexes(t *testing.T) {
+	var disabled *queryIndexes
+	disabled.record("/v1/kv", "", 1)
+
+	q := newQueryIndexes()
+	q.record("/v1/health/service", "dc1", 10)
+	q.record("/v1/health/service", "dc1", 12)
+	q.record("/v1/health/service", "dc1", 11)
+	q.record("/v1/catalog/nodes", "dc1", 0)
+
+	ch := make(chan prometheus.Metric, 10)
+	q.collect(ch)
+	close(ch)
+	// The index of the endpoint and the spread of the datacenter.
+	if len(ch) != 2 {
+		t.Fatalf("expected 2 metrics, got %d", len(ch))
+	}
+	var pb dto.Metric
+	if err := (<-ch).Write(&pb); err != nil {
+		t.Fatal(err)
+	}
+	if got := pb.Gauge.GetValue(); got != 12 {
+		t.Errorf("expected index 12, got %v", got)
+	}
+}
+
+func TestQueryIndexesSpread(t *testing.T) {
+	q := newQueryIndexes()
+	q.record("/v1/catalog/services", "dc1", 100)
+	q.record("/v1/health/state", "dc1", 104)
+	q.record("/v1/health/service", "dc1", 97)
+	q.record("/v1/kv", "", 50)
+
+	ch := make(chan prometheus.Metric, 10)
+	q.collect(ch)
+	close(ch)
+	for m := range ch {
+		if m.Desc() != indexSpread {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		if got := pb.Gauge.GetValue(); got != 7 {
+			t.Errorf("expected spread 7, got %v", got)
+		}
+		return
+	}
+	t.Error("expected index spread metric")
+}
@@ -0,0 +1,248 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	apiRequests = newCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "api_requests_total",
+			Help:      "Number of requests to the Consul API by endpoint and status code.",
+		},
+		[]string{"endpoint", "code"},
+	)
+	apiRequestDuration = newHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "api_request_duration_seconds",
+			Help:      "Latency of requests to the Consul API by endpoint.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"endpoint"},
+	)
+	queryErrors = newCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "errors_total",
+			Help:      "Number of failed queries of the Consul API during collection by endpoint and datacenter.",
+		},
+		[]string{"endpoint", "datacenter"},
+	)
+	classifiedErrors = newCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "errors_by_class_total",
+			Help:      "Number of failed queries of the Consul API during collection by endpoint and class of error: timeout, connection, 4xx, 5xx or other.",
+		},
+		[]string{"endpoint", "class"},
+	)
+	aclDenied = newCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "acl_denied_total",
+			Help:      "Number of queries of the Consul API during collection denied by ACLs, by endpoint.",
+		},
+		[]string{"endpoint"},
+	)
+	apiRetries = newCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "api_retries_total",
+			Help:      "Number of retries of failed queries of the Consul API by watches, by endpoint.",
+		},
+		[]string{"endpoint"},
+	)
+	rateLimitWait = newCounter(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "rate_limit_wait_seconds_total",
+			Help:      "Total time requests to the Consul API waited for the rate limiter.",
+		},
+	)
+	agentCacheRequests = newCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "agent_cache_requests_total",
+			Help:      "Number of requests to the Consul API served by the agent cache, by endpoint and result (hit or miss).",
+		},
+		[]string{"endpoint", "result"},
+	)
+	duplicateSeries = newCounter(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "duplicate_series_total",
+			Help:      "Number of series dropped because a series with the same name and labels was already collected.",
+		},
+	)
+)
+
+// instrumentation holds the metrics about the exporter itself. They are
+// collected through the relabeler like the metrics about Consul, so that they
+// follow the configured namespace.
+var instrumentation = []prometheus.Collector{
+	apiRequests, apiRequestDuration, queryErrors, classifiedErrors, aclDenied,
+	apiRetries, rateLimitWait, agentCacheRequests, duplicateSeries,
+}
+
+func newCounter(opts prometheus.CounterOpts) prometheus.Counter {
+	c := prometheus.NewCounter(opts)
+	recordDescs(c, prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.Help, nil)
+	return c
+}
+
+func newCounterVec(opts prometheus.CounterOpts, labelNames []string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(opts, labelNames)
+	recordDescs(c, prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.Help, labelNames)
+	return c
+}
+
+func newHistogramVec(opts prometheus.HistogramOpts, labelNames []string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(opts, labelNames)
+	recordDescs(h, prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.Help, labelNames)
+	return h
+}
+
+// queryTimeoutKey is the context key of the timeout of requests to the Consul
+// API.
+type queryTimeoutKey struct{}
+
+// withQueryTimeout returns a context whose requests to the Consul API time out
+// after timeout, 0 meaning never, instead of the client's default timeout.
+func withQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, queryTimeoutKey{}, timeout)
+}
+
+// instrumentedTransport records the number and latency of requests to the
+// Consul API, and traces them if the request context carries a span. Requests
+// are rate limited by limiter, if set, and time out after timeout or that of
+// their context once the limiter let them through.
+type instrumentedTransport struct {
+	next    http.RoundTripper
+	limiter *rateLimiter
+	timeout time.Duration
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	timeout := t.timeout
+	if d, ok := req.Context().Value(queryTimeoutKey{}).(time.Duration); ok {
+		timeout = d
+	}
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), timeout)
+		req = req.WithContext(ctx)
+	}
+
+	endpoint := apiEndpoint(req.URL.Path)
+	start := time.Now()
+	finish := tracedRequest(req, endpoint)
+	resp, err := t.next.RoundTrip(req)
+	finish(resp, err)
+	if err != nil {
+		cancel()
+	} else {
+		// The timeout covers reading the body.
+		resp.Body = cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	}
+	apiRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	apiRequests.WithLabelValues(endpoint, code).Inc()
+	if err == nil {
+		// Only endpoints supporting the agent cache set the header.
+		switch resp.Header.Get("X-Cache") {
+		case "HIT":
+			agentCacheRequests.WithLabelValues(endpoint, "hit").Inc()
+		case "MISS":
+			agentCacheRequests.WithLabelValues(endpoint, "miss").Inc()
+		}
+	}
+	return resp, err
+}
+
+// cancelBody cancels the context of its request once closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// isACLDenied reports whether err is a Consul API error caused by a missing
+// permission or an unknown token. The API client only reports the status
+// code in the error message.
+func isACLDenied(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "Unexpected response code: 403")
+}
+
+// errorClass returns the class of a failed query of the Consul API: timeout
+// if it ran out of time, connection if Consul couldn't be reached or hung up,
+// 4xx or 5xx after an error response, like a denial by ACLs or a failure of
+// the servers, and other otherwise.
+func errorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, new(*net.OpError)), errors.As(err, new(*net.DNSError)),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection"
+	}
+	const prefix = "Unexpected response code: "
+	if msg := err.Error(); strings.HasPrefix(msg, prefix) {
+		code, _ := strconv.Atoi(strings.SplitN(msg[len(prefix):], " ", 2)[0])
+		switch {
+		case code >= 500 && code < 600:
+			return "5xx"
+		case code >= 400 && code < 500:
+			return "4xx"
+		}
+	}
+	return "other"
+}
+
+// apiEndpoint returns the endpoint of an API path without its variable parts
+// like service names or keys, e.g. /v1/health/service for
+// /v1/health/service/web.
+func apiEndpoint(path string) string {
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 4)
+	n := 3
+	if len(segments) > 1 && (segments[1] == "kv" || segments[1] == "txn") {
+		n = 2
+	}
+	if len(segments) < n {
+		n = len(segments)
+	}
+	return "/" + strings.Join(segments[:n], "/")
+}
@@ -0,0 +1,58 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+func TestAPIEndpoint(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/v1/status/peers":        "/v1/status/peers",
+		"/v1/health/service/web":  "/v1/health/service",
+		"/v1/health/state/any":    "/v1/health/state",
+		"/v1/kv/config/replicas":  "/v1/kv",
+		"/v1/kv/":                 "/v1/kv",
+		"/v1/txn":                 "/v1/txn",
+		"/v1/catalog/datacenters": "/v1/catalog/datacenters",
+	} {
+		if endpoint := apiEndpoint(path); endpoint != expected {
+			t.Errorf("expected %s for %s, got %s", expected, path, endpoint)
+		}
+	}
+}
+
+func TestIsACLDenied(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil: false,
+		errors.New("Unexpected response code: 403 (Permission denied)"): true,
+		errors.New("Unexpected response code: 403 (ACL not found)"):     true,
+		errors.New("Unexpected response code: 403"):                     true,
+		errors.New("Unexpected response code: 500 (rpc error)"):         false,
+		errors.New("context deadline exceeded"):                         false,
+	} {
+		if denied := isACLDenied(err); denied != expected {
+			t.Errorf("expected %t for %v, got %t", expected, err, denied)
+		}
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	for err, expected := range map[error]string{
+		context.DeadlineExceeded: "timeout",
+		&url.Error{Op: "Get", URL: "http://consul:8500", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}: "connection",
+		&url.Error{Op: "Get", URL: "http://consul:8500", Err: io.EOF}:                                              "connection",
+		errors.New("Unexpected response code: 403 (Permission denied)"):                                            "4xx",
+		errors.New("Unexpected response code: 500 (rpc error making call: No cluster leader)"):                     "5xx",
+		errors.New("Unexpected response code: 503"):                                                                "5xx",
+		errors.New("something else"):                                                                               "other",
+	} {
+		if class := errorClass(err); class != expected {
+			t.Errorf("expected %s for %v, got %s", expected, err, class)
+		}
+	}
+}
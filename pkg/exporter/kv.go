@@ -0,0 +1,837 @@
+package exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/hcl"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+
+	consul_api "github.com/hashicorp/consul/api"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// KVConfig describes how the keys under a prefix are exported. The prefix of
+// the kv.prefix flag uses the generic consul_catalog_kv metric, prefixes in
+// the configuration file can define their own metric.
+type KVConfig struct {
+	Metric string            `hcl:"metric"`
+	Help   string            `hcl:"help"`
+	Filter string            `hcl:"filter"`
+	Labels map[string]string `hcl:"labels"`
+	// JSON flattens the numeric fields of JSON objects into one series per
+	// field, with the JSON path in the path label.
+	JSON bool `hcl:"json"`
+	// HCL flattens the numeric fields of HCL values like JSON, e.g. as
+	// rendered by consul-template.
+	HCL bool `hcl:"hcl"`
+	// YAML flattens the numeric fields of YAML values like JSON.
+	YAML bool `hcl:"yaml"`
+	// Info exports non-numeric values as an info metric with the value as
+	// label, named like the metric with an _info suffix.
+	Info bool `hcl:"info"`
+	// Bools maps boolean strings like "true" or "off" to 1 and 0.
+	Bools bool `hcl:"bools"`
+	// Flags exports the Flags field of the pairs, named like the metric with a
+	// _flags suffix.
+	Flags bool `hcl:"flags"`
+	// ModifyIndex exports the index of the last modification of the pairs,
+	// named like the metric with a _modify_index suffix.
+	ModifyIndex bool `hcl:"modify_index"`
+	// Durations parses duration strings like "30s" into seconds.
+	Durations bool `hcl:"durations"`
+	// Multiplier and Offset convert numeric values, e.g. from megabytes to
+	// bytes. Both may be integers or floats.
+	Multiplier interface{} `hcl:"multiplier"`
+	Offset     interface{} `hcl:"offset"`
+	// Timestamps exports values with the Unix timestamp stored in the
+	// companion key <key>/.ts as explicit timestamp.
+	Timestamps bool `hcl:"timestamps"`
+	// Depth limits how many levels below the prefix keys are exported, 0
+	// exports all keys.
+	Depth int `hcl:"depth"`
+	// Allow lists regexes of the keys that may be exported, anchored at both
+	// ends. Without it all keys matching the filter are exported, unless KV
+	// default-deny is enabled.
+	Allow []string `hcl:"allow"`
+	// Type is the metric type of the values, gauge or counter.
+	Type string `hcl:"type"`
+	// CounterSuffix exports keys ending with it as counters, named like the
+	// metric with a _total suffix, e.g. "_total" for requests_total.
+	CounterSuffix string `hcl:"counter_suffix"`
+	// Decode lists the decodings applied to values before parsing, in order,
+	// e.g. ["base64", "gzip"].
+	Decode []string `hcl:"decode"`
+	// SkipDirs skips directory placeholder keys, which end with a slash.
+	SkipDirs bool `hcl:"skip_dirs"`
+
+	prefix      string
+	filter      *regexp.Regexp
+	desc        *prometheus.Desc
+	valueType   prometheus.ValueType
+	counterDesc *prometheus.Desc
+	infoDesc    *prometheus.Desc
+	flagsDesc   *prometheus.Desc
+	indexDesc   *prometheus.Desc
+	labelValues []string
+	boolValues  map[string]float64
+	multiplier  float64
+	offset      float64
+	watcher     *watcher
+	allow       []*regexp.Regexp
+	defaultDeny bool
+	// flag is set for the prefix of the kv.prefix flag.
+	flag bool
+}
+
+const (
+	kvTypeGauge   = "gauge"
+	kvTypeCounter = "counter"
+)
+
+const (
+	kvDecodeBase64 = "base64"
+	kvDecodeGzip   = "gzip"
+)
+
+// maxTxnOps is the maximum number of operations in a Consul transaction.
+const maxTxnOps = 64
+
+// kvSeparator separates the levels of keys.
+const kvSeparator = "/"
+
+// defaultKVBoolValues is the default mapping of boolean strings, which can be
+// overridden in the configuration file.
+var defaultKVBoolValues = map[string]int{
+	"true":     1,
+	"false":    0,
+	"yes":      1,
+	"no":       0,
+	"on":       1,
+	"off":      0,
+	"enabled":  1,
+	"disabled": 0,
+}
+
+// init compiles the filter and builds the descriptor of the prefix. It can be
+// called again on an initialized config.
+func (kc *KVConfig) init(prefix string, boolValues map[string]int) (err error) {
+	kc.prefix = prefix
+	if kc.Depth < 0 {
+		return fmt.Errorf("invalid depth %d", kc.Depth)
+	}
+	switch kc.Type {
+	case "", kvTypeGauge:
+		kc.valueType = prometheus.GaugeValue
+	case kvTypeCounter:
+		kc.valueType = prometheus.CounterValue
+	default:
+		return fmt.Errorf("unknown type %q", kc.Type)
+	}
+	for _, d := range kc.Decode {
+		if d != kvDecodeBase64 && d != kvDecodeGzip {
+			return fmt.Errorf("unknown decoding %q", d)
+		}
+	}
+	if kc.Bools {
+		kc.boolValues = make(map[string]float64, len(boolValues))
+		for s, v := range boolValues {
+			kc.boolValues[strings.ToLower(s)] = float64(v)
+		}
+	}
+	kc.multiplier, kc.offset = 1, 0
+	if kc.Multiplier != nil {
+		if kc.multiplier, err = numberValue(kc.Multiplier); err != nil {
+			return fmt.Errorf("invalid multiplier: %s", err)
+		}
+	}
+	if kc.Offset != nil {
+		if kc.offset, err = numberValue(kc.Offset); err != nil {
+			return fmt.Errorf("invalid offset: %s", err)
+		}
+	}
+	if kc.Filter == "" {
+		kc.Filter = ".*"
+	}
+	filter, err := regexp.Compile(kc.Filter)
+	if err != nil {
+		return fmt.Errorf("invalid filter: %s", err)
+	}
+	kc.filter = filter
+	kc.allow = nil
+	for _, a := range kc.Allow {
+		re, err := regexp.Compile("^(?:" + a + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid allow: %s", err)
+		}
+		kc.allow = append(kc.allow, re)
+	}
+
+	if kc.Metric == "" {
+		return fmt.Errorf("metric is required")
+	}
+	if !model.IsValidMetricName(model.LabelValue(kc.Metric)) {
+		return fmt.Errorf("invalid metric name %q", kc.Metric)
+	}
+	if kc.Help == "" {
+		kc.Help = fmt.Sprintf("Values of the keys under %q in Consul's key/value catalog.", prefix)
+	}
+
+	// Named capture groups of the filter become labels, followed by the
+	// static labels.
+	var extra []string
+	for _, name := range filter.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if name == "key" || name == "path" || name == "value" {
+			return fmt.Errorf("capture group %q clashes with a built-in label", name)
+		}
+		extra = append(extra, name)
+	}
+	static := make([]string, 0, len(kc.Labels))
+	for name := range kc.Labels {
+		static = append(static, name)
+	}
+	sort.Strings(static)
+	kc.labelValues = make([]string, len(static))
+	for i, name := range static {
+		kc.labelValues[i] = kc.Labels[name]
+	}
+	extra = append(extra, static...)
+	if err := checkKVLabelNames(extra); err != nil {
+		return err
+	}
+
+	labelNames := []string{"key"}
+	if kc.JSON || kc.HCL || kc.YAML {
+		labelNames = append(labelNames, "path")
+	}
+	kc.desc = newDesc(kc.Metric, kc.Help, append(labelNames, extra...))
+	if kc.CounterSuffix != "" && kc.valueType != prometheus.CounterValue {
+		kc.counterDesc = newDesc(
+			kc.Metric+"_total",
+			fmt.Sprintf("Values of the keys ending with %q under %q in Consul's key/value catalog.", kc.CounterSuffix, prefix),
+			append(labelNames, extra...),
+		)
+	}
+	if kc.Info {
+		kc.infoDesc = newDesc(
+			kc.Metric+"_info",
+			fmt.Sprintf("Non-numeric values of the keys under %q in Consul's key/value catalog.", prefix),
+			append([]string{"key", "value"}, extra...),
+		)
+	}
+	if kc.Flags {
+		kc.flagsDesc = newDesc(
+			kc.Metric+"_flags",
+			fmt.Sprintf("Flags of the keys under %q in Consul's key/value catalog.", prefix),
+			append([]string{"key"}, extra...),
+		)
+	}
+	if kc.ModifyIndex {
+		kc.indexDesc = newDesc(
+			kc.Metric+"_modify_index",
+			fmt.Sprintf("Raft index of the last modification of the keys under %q in Consul's key/value catalog.", prefix),
+			append([]string{"key"}, extra...),
+		)
+	}
+	return nil
+}
+
+// checkKVLabelNames makes sure the capture group and static labels of a prefix
+// are valid, distinct, and don't clash with the built-in key, path and value
+// labels.
+func checkKVLabelNames(names []string) error {
+	seen := map[string]bool{"key": true, "path": true, "value": true}
+	for _, name := range names {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("label %q is defined twice or clashes with a built-in label", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// kvConfigs returns the configs of all prefixes to collect, the kv.prefix flag
+// first and the configuration file's prefixes in lexicographical order. The
+// kv.prefix flag's prefix is exported as consul_catalog_kv.
+func kvConfigs(kvPrefix, kvFilter string, cfg *Config) ([]*KVConfig, error) {
+	boolValues := cfg.KVBoolValues
+	if len(boolValues) == 0 {
+		boolValues = defaultKVBoolValues
+	}
+
+	var kcs []*KVConfig
+	if kvPrefix != "" {
+		kc := cfg.KVFlag
+		kc.Metric = prometheus.BuildFQName(Namespace, "", "catalog_kv")
+		kc.Help = keyValuesHelp
+		kc.Filter = kvFilter
+		if err := kc.init(kvPrefix, boolValues); err != nil {
+			return nil, err
+		}
+		kc.defaultDeny = cfg.KVDefaultDeny
+		kc.flag = true
+		kcs = append(kcs, &kc)
+	}
+
+	prefixes := make([]string, 0, len(cfg.KV))
+	for prefix := range cfg.KV {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		// The config is copied, so that exporters created from the same
+		// Config don't share their watchers.
+		c := *cfg.KV[prefix]
+		kc := &c
+		if err := kc.init(prefix, boolValues); err != nil {
+			return nil, fmt.Errorf("invalid kv config for %q: %s", prefix, err)
+		}
+		kc.defaultDeny = cfg.KVDefaultDeny
+		kcs = append(kcs, kc)
+	}
+	for _, kc := range kcs {
+		if kc.defaultDeny && len(kc.allow) == 0 {
+			logger.Warn("No keys are allowed, KV default-deny is enabled", "prefix", kc.prefix)
+		}
+	}
+	return kcs, nil
+}
+
+// allowed reports whether the key may be exported according to the
+// allowlist.
+func (kc *KVConfig) allowed(key string) bool {
+	if len(kc.allow) == 0 {
+		return !kc.defaultDeny
+	}
+	for _, re := range kc.allow {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	registerCollector(collectorKV, kvCollector{}, true)
+}
+
+// kvCollector collects the values of the configured KV prefixes.
+type kvCollector struct{}
+
+func (kvCollector) collect(s *scrape, ch chan<- prometheus.Metric) {
+	defer s.e.observe(ch, collectorKV, "", time.Now())
+	s.e.collectKeyValues(s.ctx, ch)
+}
+
+func (e *Exporter) collectKeyValues(ctx context.Context, ch chan<- prometheus.Metric) {
+	ctx, span := e.tracer.start(ctx, "collect kv")
+	defer span.finish(nil)
+
+	if e.kvTxn {
+		snapshot, err := e.kvSnapshot(ctx)
+		if err != nil {
+			e.queryError("/v1/txn", "", err)
+			return
+		}
+		for _, kc := range e.kvConfigs {
+			var pairs consul_api.KVPairs
+			for _, pair := range snapshot {
+				if strings.HasPrefix(pair.Key, kc.prefix) {
+					pairs = append(pairs, pair)
+				}
+			}
+			e.collectPairs(ch, kc, pairs)
+		}
+		return
+	}
+
+	for _, kc := range e.kvConfigs {
+		pairs, err := e.listPrefix(ctx, kc)
+		if err != nil {
+			e.queryError("/v1/kv", "", err)
+			continue
+		}
+		e.collectPairs(ch, kc, pairs)
+	}
+}
+
+// kvSnapshot reads all prefixes in a single read-only transaction, so that
+// the pairs are consistent with each other.
+func (e *Exporter) kvSnapshot(ctx context.Context) (consul_api.KVPairs, error) {
+	queryOptions, cancel := e.queryOptions(ctx, "", EndpointKV)
+	defer cancel()
+
+	ops := make(consul_api.KVTxnOps, 0, len(e.kvConfigs))
+	for _, kc := range e.kvConfigs {
+		ops = append(ops, &consul_api.KVTxnOp{Verb: consul_api.KVGetTree, Key: kc.prefix})
+	}
+	ok, resp, meta, err := e.client.KV().Txn(ops, queryOptions)
+	if err != nil {
+		return nil, err
+	}
+	e.indexes.record("/v1/txn", "", meta.LastIndex)
+	if !ok {
+		var errs []string
+		for _, txnErr := range resp.Errors {
+			errs = append(errs, txnErr.What)
+		}
+		return nil, fmt.Errorf("transaction failed: %s", strings.Join(errs, ", "))
+	}
+
+	var pairs consul_api.KVPairs
+	for _, pair := range resp.Results {
+		if pair != nil {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs, nil
+}
+
+// listPrefix returns the pairs under the prefix, from the watch cache if
+// enabled.
+func (e *Exporter) listPrefix(ctx context.Context, kc *KVConfig) (consul_api.KVPairs, error) {
+	if kc.watcher != nil {
+		e.indexes.record("/v1/kv", "", kc.watcher.lastIndex())
+		v, err := kc.watcher.get()
+		pairs, _ := v.(consul_api.KVPairs)
+		return pairs, err
+	}
+
+	queryOptions, cancel := e.queryOptions(ctx, "", EndpointKV)
+	defer cancel()
+
+	if kc.Depth > 0 {
+		return e.walkPrefix(kc, queryOptions)
+	}
+	pairs, meta, err := e.client.KV().List(kc.prefix, queryOptions)
+	if err != nil {
+		return nil, err
+	}
+	e.indexes.record("/v1/kv", "", meta.LastIndex)
+	return pairs, nil
+}
+
+// walkPrefix returns the pairs at most Depth levels below the prefix. Consul
+// can't list values down to a depth, so the keys are listed level by level
+// and only those within the depth are read. Directory placeholder keys of the
+// deepest level aren't listed and thus not read.
+func (e *Exporter) walkPrefix(kc *KVConfig, queryOptions *consul_api.QueryOptions) (consul_api.KVPairs, error) {
+	var (
+		ops   consul_api.KVTxnOps
+		index uint64
+	)
+	for level := []string{kc.prefix}; len(level) > 0; {
+		var next []string
+		for _, prefix := range level {
+			keys, meta, err := e.client.KV().Keys(prefix, kvSeparator, queryOptions)
+			if err != nil {
+				return nil, err
+			}
+			if meta.LastIndex > index {
+				index = meta.LastIndex
+			}
+			listed := make(map[string]bool, len(keys))
+			for _, key := range keys {
+				listed[key] = true
+			}
+			for _, key := range keys {
+				switch {
+				case !strings.HasSuffix(key, kvSeparator) || key == prefix:
+					ops = append(ops, &consul_api.KVTxnOp{Verb: consul_api.KVGet, Key: key})
+				case kc.withinDepth(key + "-"):
+					// The children of the directory are within the depth.
+					next = append(next, key)
+				case kc.Timestamps && listed[strings.TrimSuffix(key, kvSeparator)]:
+					// The companion timestamp key is one level below the
+					// deepest level. Reading it as a tree doesn't fail if
+					// it doesn't exist.
+					ops = append(ops, &consul_api.KVTxnOp{Verb: consul_api.KVGetTree, Key: strings.TrimSuffix(key, kvSeparator) + kvTimestampSuffix})
+				}
+			}
+		}
+		level = next
+	}
+
+	pairs, txnIndex, err := e.kvRead(ops, queryOptions)
+	if err != nil {
+		return nil, err
+	}
+	if txnIndex > index {
+		index = txnIndex
+	}
+	e.indexes.record("/v1/kv", "", index)
+
+	limited := pairs[:0:0]
+	for _, pair := range pairs {
+		key := pair.Key
+		if kc.Timestamps {
+			key = strings.TrimSuffix(key, kvTimestampSuffix)
+		}
+		if kc.withinDepth(key) {
+			limited = append(limited, pair)
+		}
+	}
+	return limited, nil
+}
+
+// kvRead runs the read operations in transactions of at most maxTxnOps
+// operations. Reads of keys deleted since they were listed fail their
+// transaction, which is then run again without them.
+func (e *Exporter) kvRead(ops consul_api.KVTxnOps, queryOptions *consul_api.QueryOptions) (consul_api.KVPairs, uint64, error) {
+	var (
+		pairs consul_api.KVPairs
+		index uint64
+	)
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		batch := ops[:n]
+		ops = ops[n:]
+		for len(batch) > 0 {
+			ok, resp, meta, err := e.client.KV().Txn(batch, queryOptions)
+			if err != nil {
+				return nil, 0, err
+			}
+			if meta.LastIndex > index {
+				index = meta.LastIndex
+			}
+			if ok {
+				for _, pair := range resp.Results {
+					if pair != nil {
+						pairs = append(pairs, pair)
+					}
+				}
+				break
+			}
+
+			failed := map[int]bool{}
+			var errs []string
+			for _, txnErr := range resp.Errors {
+				failed[txnErr.OpIndex] = true
+				errs = append(errs, txnErr.What)
+			}
+			remaining := batch[:0:0]
+			for i, op := range batch {
+				if !failed[i] {
+					remaining = append(remaining, op)
+				}
+			}
+			if len(remaining) == len(batch) {
+				return nil, 0, fmt.Errorf("transaction failed: %s", strings.Join(errs, ", "))
+			}
+			batch = remaining
+		}
+	}
+	return pairs, index, nil
+}
+
+// withinDepth reports whether the key is at most Depth levels below the
+// prefix.
+func (kc *KVConfig) withinDepth(key string) bool {
+	if kc.Depth <= 0 {
+		return true
+	}
+	rel := strings.Trim(strings.TrimPrefix(key, kc.prefix), kvSeparator)
+	return strings.Count(rel, kvSeparator) < kc.Depth
+}
+
+func (e *Exporter) collectPairs(ch chan<- prometheus.Metric, kc *KVConfig, pairs consul_api.KVPairs) {
+	var timestamps map[string]time.Time
+	if kc.Timestamps {
+		timestamps = kvTimestamps(pairs, e.redactor)
+	}
+
+	for _, pair := range pairs {
+		if kc.Timestamps && strings.HasSuffix(pair.Key, kvTimestampSuffix) {
+			continue
+		}
+		if kc.SkipDirs && strings.HasSuffix(pair.Key, kvSeparator) {
+			continue
+		}
+		// Watches list the whole prefix.
+		if !kc.withinDepth(pair.Key) {
+			continue
+		}
+		match := kc.filter.FindStringSubmatch(pair.Key)
+		if match == nil || !kc.allowed(pair.Key) {
+			continue
+		}
+		labels := kc.extraLabelValues(match)
+
+		if kc.flagsDesc != nil {
+			ch <- prometheus.MustNewConstMetric(
+				kc.flagsDesc, prometheus.GaugeValue, float64(pair.Flags), append([]string{pair.Key}, labels...)...,
+			)
+		}
+		if kc.indexDesc != nil {
+			ch <- prometheus.MustNewConstMetric(
+				kc.indexDesc, prometheus.GaugeValue, float64(pair.ModifyIndex), append([]string{pair.Key}, labels...)...,
+			)
+		}
+
+		raw, err := kc.decode(pair.Value)
+		if err != nil {
+			logger.Debug("Skipping key, its value can't be decoded", e.redactor.logArgs(pair.Key, err)...)
+			continue
+		}
+		sensitive := e.redactor.sensitive(pair.Key)
+		if !sensitive {
+			e.snapshot.setKV(pair.Key, raw)
+		}
+		samples := kc.parse(raw)
+		if len(samples) == 0 && kc.infoDesc != nil {
+			// Info series would expose the value as label.
+			if sensitive {
+				continue
+			}
+			value := string(raw)
+			if len(value) > maxKVInfoValueLength || !utf8.ValidString(value) {
+				logger.Debug("Skipping info of key, its value is too long or not valid UTF-8", "key", pair.Key)
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				kc.infoDesc, prometheus.GaugeValue, 1, append([]string{pair.Key, value}, labels...)...,
+			)
+			continue
+		}
+		desc, valueType := kc.desc, kc.valueType
+		if kc.counterDesc != nil && strings.HasSuffix(pair.Key, kc.CounterSuffix) {
+			desc, valueType = kc.counterDesc, prometheus.CounterValue
+		}
+		for _, sample := range samples {
+			labelValues := []string{pair.Key}
+			if kc.JSON || kc.HCL || kc.YAML {
+				labelValues = append(labelValues, sample.path)
+			}
+			var m prometheus.Metric = prometheus.MustNewConstMetric(
+				desc, valueType, sample.value, append(labelValues, labels...)...,
+			)
+			if ts, ok := timestamps[pair.Key]; ok {
+				m = timestampedMetric{Metric: m, t: ts}
+			}
+			ch <- m
+		}
+	}
+}
+
+// kvTimestampSuffix is the suffix of companion keys holding the timestamp of
+// a key's value.
+const kvTimestampSuffix = "/.ts"
+
+// kvTimestamps returns the timestamps of the companion keys in pairs, indexed
+// by the key they belong to. Invalid timestamps of sensitive keys are logged
+// redacted.
+func kvTimestamps(pairs consul_api.KVPairs, r *redactor) map[string]time.Time {
+	timestamps := map[string]time.Time{}
+	for _, pair := range pairs {
+		if !strings.HasSuffix(pair.Key, kvTimestampSuffix) {
+			continue
+		}
+		ts, err := strconv.ParseFloat(strings.TrimSpace(string(pair.Value)), 64)
+		if err != nil {
+			logger.Debug("Ignoring invalid timestamp", r.logArgs(pair.Key, err)...)
+			continue
+		}
+		sec, frac := math.Modf(ts)
+		timestamps[strings.TrimSuffix(pair.Key, kvTimestampSuffix)] = time.Unix(int64(sec), int64(frac*1e9))
+	}
+	return timestamps
+}
+
+// timestampedMetric is a metric with an explicit timestamp.
+type timestampedMetric struct {
+	prometheus.Metric
+	t time.Time
+}
+
+func (m timestampedMetric) Write(pb *dto.Metric) error {
+	if err := m.Metric.Write(pb); err != nil {
+		return err
+	}
+	pb.TimestampMs = proto.Int64(m.t.UnixNano() / int64(time.Millisecond))
+	return nil
+}
+
+// extraLabelValues returns the values of the named capture groups in the
+// filter's match, followed by the values of the static labels.
+func (kc *KVConfig) extraLabelValues(match []string) []string {
+	var values []string
+	for i, name := range kc.filter.SubexpNames() {
+		if i > 0 && name != "" {
+			values = append(values, match[i])
+		}
+	}
+	return append(values, kc.labelValues...)
+}
+
+// kvSample is a numeric value extracted from a key/value pair.
+type kvSample struct {
+	// path is the JSON path of the value, empty for plain values.
+	path  string
+	value float64
+}
+
+// decode applies the configured decodings to a raw value, in order. It fails
+// if the value isn't encoded as configured.
+func (kc *KVConfig) decode(value []byte) ([]byte, error) {
+	for _, d := range kc.Decode {
+		switch d {
+		case kvDecodeBase64:
+			decoded := make([]byte, base64.StdEncoding.DecodedLen(len(value)))
+			n, err := base64.StdEncoding.Decode(decoded, bytes.TrimSpace(value))
+			if err != nil {
+				return nil, err
+			}
+			value = decoded[:n]
+		case kvDecodeGzip:
+			r, err := gzip.NewReader(bytes.NewReader(value))
+			if err != nil {
+				return nil, err
+			}
+			if value, err = ioutil.ReadAll(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+// parse extracts the numeric values of a key/value pair. Values which can't
+// be parsed result in no samples.
+func (kc *KVConfig) parse(value []byte) []kvSample {
+	if val, err := strconv.ParseFloat(string(value), 64); err == nil {
+		return []kvSample{{value: kc.scale(val)}}
+	}
+	if val, ok := kc.boolValues[strings.ToLower(strings.TrimSpace(string(value)))]; ok {
+		return []kvSample{{value: val}}
+	}
+	if kc.Durations {
+		if d, err := time.ParseDuration(strings.TrimSpace(string(value))); err == nil {
+			return []kvSample{{value: kc.scale(d.Seconds())}}
+		}
+	}
+
+	var v interface{}
+	switch {
+	case kc.JSON && json.Unmarshal(value, &v) == nil:
+	case kc.HCL && hcl.Unmarshal(value, &v) == nil:
+		v = normalizeHCL(v)
+	case kc.YAML && yaml.Unmarshal(value, &v) == nil:
+		v = normalizeYAML(v)
+	default:
+		return nil
+	}
+	var samples []kvSample
+	flattenJSON("", v, &samples)
+	for i := range samples {
+		samples[i].value = kc.scale(samples[i].value)
+	}
+	return samples
+}
+
+// scale applies the multiplier and offset to a numeric value.
+func (kc *KVConfig) scale(v float64) float64 {
+	return v*kc.multiplier + kc.offset
+}
+
+// normalizeHCL converts decoded HCL to the types of decoded JSON. HCL decodes
+// integers as int and each block as a list of objects, single blocks are
+// unwrapped so that their paths don't contain an index.
+func normalizeHCL(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = normalizeHCL(child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = normalizeHCL(child)
+		}
+		return v
+	case []map[string]interface{}:
+		if len(v) == 1 {
+			return normalizeHCL(v[0])
+		}
+		list := make([]interface{}, len(v))
+		for i, child := range v {
+			list[i] = normalizeHCL(child)
+		}
+		return list
+	}
+	return v
+}
+
+// normalizeYAML converts decoded YAML to the types of decoded JSON. YAML
+// decodes integers as int and mappings with interface{} keys.
+func normalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			m[fmt.Sprint(key)] = normalizeYAML(child)
+		}
+		return m
+	case []interface{}:
+		for i, child := range v {
+			v[i] = normalizeYAML(child)
+		}
+		return v
+	}
+	return v
+}
+
+// flattenJSON appends the numeric leaves of v to samples, with their paths in
+// dotted notation (e.g. limits.conns or backends.0.weight).
+func flattenJSON(path string, v interface{}, samples *[]kvSample) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	switch v := v.(type) {
+	case float64:
+		*samples = append(*samples, kvSample{path: path, value: v})
+	case map[string]interface{}:
+		for key, child := range v {
+			flattenJSON(join(key), child, samples)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenJSON(join(strconv.Itoa(i)), child, samples)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+func TestKVConfigParse(t *testing.T) {
+	cases := []struct {
+		kc      KVConfig
+		value   string
+		samples []kvSample
+	}{
+		{kc: KVConfig{}, value: "42", samples: []kvSample{{value: 42}}},
+		{kc: KVConfig{}, value: "fuuuu", samples: nil},
+		{kc: KVConfig{}, value: `{"a": 1}`, samples: nil},
+		{kc: KVConfig{JSON: true}, value: "42", samples: []kvSample{{value: 42}}},
+		{kc: KVConfig{}, value: "true", samples: nil},
+		{kc: KVConfig{multiplier: 1024 * 1024}, value: "2", samples: []kvSample{{value: 2 * 1024 * 1024}}},
+		{kc: KVConfig{multiplier: 1, offset: -273}, value: "300", samples: []kvSample{{value: 27}}},
+		{kc: KVConfig{}, value: "30s", samples: nil},
+		{kc: KVConfig{Durations: true}, value: "1m30s", samples: []kvSample{{value: 90}}},
+		{kc: KVConfig{Durations: true, multiplier: 1000}, value: "1.5s", samples: []kvSample{{value: 1500}}},
+		{kc: KVConfig{boolValues: map[string]float64{"on": 1, "off": 0}}, value: "Off\n", samples: []kvSample{{value: 0}}},
+		{kc: KVConfig{boolValues: map[string]float64{"on": 1, "off": 0}}, value: "true", samples: nil},
+		{
+			kc:    KVConfig{JSON: true},
+			value: `{"limits": {"conns": 100, "name": "x"}, "backends": [{"weight": 2}], "enabled": true}`,
+			samples: []kvSample{
+				{path: "backends.0.weight", value: 2},
+				{path: "limits.conns", value: 100},
+			},
+		},
+		{
+			kc:    KVConfig{HCL: true},
+			value: "limits {\n  conns = 100\n  ratio = 0.5\n}\nports = [80, 443]\nname = \"x\"\n",
+			samples: []kvSample{
+				{path: "limits.conns", value: 100},
+				{path: "limits.ratio", value: 0.5},
+				{path: "ports.0", value: 80},
+				{path: "ports.1", value: 443},
+			},
+		},
+		{
+			kc:    KVConfig{YAML: true},
+			value: "limits:\n  conns: 100\n  ratio: 0.5\nports: [80, 443]\n1: 7\nname: x\n",
+			samples: []kvSample{
+				{path: "1", value: 7},
+				{path: "limits.conns", value: 100},
+				{path: "limits.ratio", value: 0.5},
+				{path: "ports.0", value: 80},
+				{path: "ports.1", value: 443},
+			},
+		},
+		{kc: KVConfig{YAML: true}, value: "{ unterminated", samples: nil},
+	}
+
+	for _, test := range cases {
+		if test.kc.multiplier == 0 {
+			test.kc.multiplier = 1
+		}
+		samples := test.kc.parse([]byte(test.value))
+		sort.Slice(samples, func(i, j int) bool { return samples[i].path < samples[j].path })
+		if !reflect.DeepEqual(samples, test.samples) {
+			t.Errorf("expected %v for %q, got %v", test.samples, test.value, samples)
+		}
+	}
+}
+
+func TestKVConfigCaptureLabels(t *testing.T) {
+	kc := &KVConfig{
+		Metric: "app_replicas",
+		Filter: "config/(?P<service>[^/]+)/(?P<setting>[^/]+)$",
+		Labels: map[string]string{"team": "payments"},
+	}
+	if err := kc.init("config/", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	match := kc.filter.FindStringSubmatch("config/web/replicas")
+	if match == nil {
+		t.Fatal("expected key to match")
+	}
+	expected := []string{"web", "replicas", "payments"}
+	if labels := kc.extraLabelValues(match); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected %v, got %v", expected, labels)
+	}
+
+	kc = &KVConfig{Metric: "app", Filter: "(?P<key>.*)"}
+	if err := kc.init("config/", nil); err == nil {
+		t.Errorf("expected error for capture group clashing with the key label")
+	}
+}
+
+func TestKVConfigInvalid(t *testing.T) {
+	for name, kc := range map[string]*KVConfig{
+		"metric name":             {Metric: "app-replicas"},
+		"label name":              {Metric: "app", Labels: map[string]string{"team-name": "payments"}},
+		"reserved label name":     {Metric: "app", Labels: map[string]string{"__team": "payments"}},
+		"built-in label":          {Metric: "app", Labels: map[string]string{"path": "limits"}},
+		"capture group and label": {Metric: "app", Filter: "config/(?P<service>[^/]+)", Labels: map[string]string{"service": "web"}},
+	} {
+		if err := kc.init("config/", nil); err == nil {
+			t.Errorf("expected error for invalid %s", name)
+		}
+	}
+}
+
+func TestKVTimestamps(t *testing.T) {
+	pairs := consul_api.KVPairs{
+		{Key: "jobs/backup", Value: []byte("1")},
+		{Key: "jobs/backup/.ts", Value: []byte("1500000000.5")},
+		{Key: "jobs/cleanup/.ts", Value: []byte("fuuuu")},
+	}
+
+	timestamps := kvTimestamps(pairs, nil)
+	expected := map[string]time.Time{"jobs/backup": time.Unix(1500000000, 5e8)}
+	if !reflect.DeepEqual(timestamps, expected) {
+		t.Errorf("expected %v, got %v", expected, timestamps)
+	}
+}
+
+func TestKVConfigWithinDepth(t *testing.T) {
+	kc := &KVConfig{Metric: "app", Depth: 2}
+	if err := kc.init("config/", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]bool{
+		"config/replicas":       true,
+		"config/web/":           true,
+		"config/web/replicas":   true,
+		"config/web/limits/max": false,
+	} {
+		if within := kc.withinDepth(key); within != expected {
+			t.Errorf("expected %t for %q, got %t", expected, key, within)
+		}
+	}
+}
+
+func TestKVConfigDecode(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("42"))
+	w.Close()
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	kc := &KVConfig{Metric: "app", Decode: []string{"base64", "gzip"}}
+	if err := kc.init("config/", nil); err != nil {
+		t.Fatal(err)
+	}
+	value, err := kc.decode([]byte(encoded + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "42" {
+		t.Errorf("expected 42, got %q", value)
+	}
+	if _, err := kc.decode([]byte("42")); err == nil {
+		t.Errorf("expected error for invalid base64")
+	}
+
+	kc = &KVConfig{Metric: "app", Decode: []string{"rot13"}}
+	if err := kc.init("config/", nil); err == nil {
+		t.Errorf("expected error for unknown decoding")
+	}
+}
+
+func TestKVConfigAllowed(t *testing.T) {
+	kc := &KVConfig{Metric: "app", Allow: []string{"config/[a-z]+/replicas"}}
+	if err := kc.init("config/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !kc.allowed("config/web/replicas") {
+		t.Errorf("expected config/web/replicas to be allowed")
+	}
+	if kc.allowed("config/web/replicas/token") {
+		t.Errorf("expected allow list to be anchored")
+	}
+
+	kc = &KVConfig{Metric: "app", defaultDeny: true}
+	if err := kc.init("config/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if kc.allowed("config/web/replicas") {
+		t.Errorf("expected keys to be denied without allow list")
+	}
+}
+
+func TestKVConfigsReused(t *testing.T) {
+	cfg := &Config{KV: map[string]*KVConfig{
+		"config/": {Metric: "app_config", Labels: map[string]string{"team": "payments"}, Allow: []string{"config/.*"}},
+	}}
+	for i := 0; i < 2; i++ {
+		e, err := New(ConsulOpts{URI: "localhost:8500"}, WithConfig(cfg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		kc := e.kvConfigs[0]
+		if len(kc.labelValues) != 1 || len(kc.allow) != 1 {
+			t.Errorf("expected one label value and allow regex after %d exporters, got %v and %v", i+1, kc.labelValues, kc.allow)
+		}
+	}
+}
+
+// kvServer serves the keys and transaction endpoints of a KV store. It
+// counts the keys read by transactions and fails those reading missing keys.
+type kvServer struct {
+	*httptest.Server
+	store map[string]string
+	read  []string
+	txns  int
+}
+
+func newKVServer(store map[string]string) *kvServer {
+	s := &kvServer{store: store}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Consul-Index", "3")
+		if r.URL.Path == "/v1/txn" {
+			s.txn(w, r)
+			return
+		}
+		prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		separator := r.URL.Query().Get("separator")
+		seen := map[string]bool{}
+		keys := []string{}
+		for key := range s.store {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if i := strings.Index(key[len(prefix):], separator); separator != "" && i >= 0 {
+				key = key[:len(prefix)+i+len(separator)]
+			}
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		json.NewEncoder(w).Encode(keys)
+	}))
+	return s
+}
+
+func (s *kvServer) txn(w http.ResponseWriter, r *http.Request) {
+	var ops consul_api.TxnOps
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(ops) > maxTxnOps {
+		http.Error(w, "too many operations", http.StatusRequestEntityTooLarge)
+		return
+	}
+	s.txns++
+	var resp consul_api.TxnResponse
+	for i, op := range ops {
+		switch op.KV.Verb {
+		case consul_api.KVGet:
+			value, ok := s.store[op.KV.Key]
+			if !ok {
+				resp.Errors = append(resp.Errors, &consul_api.TxnError{OpIndex: i, What: fmt.Sprintf("key %q doesn't exist", op.KV.Key)})
+				continue
+			}
+			s.read = append(s.read, op.KV.Key)
+			resp.Results = append(resp.Results, &consul_api.TxnResult{KV: &consul_api.KVPair{Key: op.KV.Key, Value: []byte(value)}})
+		case consul_api.KVGetTree:
+			for key, value := range s.store {
+				if strings.HasPrefix(key, op.KV.Key) {
+					s.read = append(s.read, key)
+					resp.Results = append(resp.Results, &consul_api.TxnResult{KV: &consul_api.KVPair{Key: key, Value: []byte(value)}})
+				}
+			}
+		}
+	}
+	if len(resp.Errors) > 0 {
+		w.WriteHeader(http.StatusConflict)
+		resp.Results = nil
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func listPrefixKeys(t *testing.T, store map[string]string, kc *KVConfig) ([]string, *kvServer) {
+	server := newKVServer(store)
+	defer server.Close()
+
+	e, err := New(ConsulOpts{URI: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kc.init("config/", nil); err != nil {
+		t.Fatal(err)
+	}
+	pairs, err := e.listPrefix(context.Background(), kc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, pair := range pairs {
+		keys = append(keys, pair.Key)
+	}
+	sort.Strings(keys)
+	sort.Strings(server.read)
+	return keys, server
+}
+
+func TestListPrefixDepth(t *testing.T) {
+	store := map[string]string{
+		"config/replicas":       "3",
+		"config/web/":           "",
+		"config/web/replicas":   "2",
+		"config/web/limits/max": "1",
+	}
+	keys, server := listPrefixKeys(t, store, &KVConfig{Metric: "app", Depth: 2})
+	if expected := []string{"config/replicas", "config/web/", "config/web/replicas"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+	// Keys below the depth aren't read.
+	if !reflect.DeepEqual(server.read, keys) {
+		t.Errorf("expected only %v to be read, got %v", keys, server.read)
+	}
+}
+
+func TestListPrefixDepthTimestamps(t *testing.T) {
+	store := map[string]string{
+		"config/replicas":         "3",
+		"config/replicas/.ts":     "1500000000",
+		"config/web/replicas":     "2",
+		"config/web/replicas/.ts": "1500000001",
+		"config/web/limits/max":   "1",
+	}
+	keys, _ := listPrefixKeys(t, store, &KVConfig{Metric: "app", Depth: 2, Timestamps: true})
+	expected := []string{"config/replicas", "config/replicas/.ts", "config/web/replicas", "config/web/replicas/.ts"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+}
+
+func TestListPrefixDepthBatches(t *testing.T) {
+	store := map[string]string{}
+	for i := 0; i < 2*maxTxnOps+1; i++ {
+		store[fmt.Sprintf("config/%03d", i)] = "1"
+	}
+	keys, server := listPrefixKeys(t, store, &KVConfig{Metric: "app", Depth: 1})
+	if len(keys) != len(store) {
+		t.Errorf("expected %d keys, got %d", len(store), len(keys))
+	}
+	if server.txns != 3 {
+		t.Errorf("expected 3 transactions, got %d", server.txns)
+	}
+}
@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"sync/atomic"
+	"time"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// leaderRetryInterval is the delay before retrying to acquire the leader
+// lock after an error.
+const leaderRetryInterval = 10 * time.Second
+
+// leaderElection holds a Consul lock, so that only one of several exporter
+// replicas collects while the others stand by. Its methods are safe to call
+// on a nil value, which is always the leader.
+type leaderElection struct {
+	key    string
+	kv     *consul_api.KV
+	lock   *consul_api.Lock
+	leader int32
+	// readiness is set by the election while standing by, as standbys don't
+	// collect.
+	readiness *readiness
+}
+
+func newLeaderElection(client *consul_api.Client, key string, r *readiness) (*leaderElection, error) {
+	lock, err := client.LockOpts(&consul_api.LockOptions{
+		Key:            key,
+		SessionName:    "consul_exporter",
+		MonitorRetries: 3,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &leaderElection{key: key, kv: client.KV(), lock: lock, readiness: r}, nil
+}
+
+// run acquires the lock and reacquires it whenever it is lost.
+func (l *leaderElection) run() {
+	for {
+		if err := l.attempt(); err != nil {
+			logger.Error("Can't read leader lock", "key", l.key, "err", err)
+			l.readiness.set(err)
+			time.Sleep(leaderRetryInterval)
+			continue
+		}
+		lost, err := l.lock.Lock(nil)
+		if err != nil {
+			logger.Error("Can't acquire leader lock", "key", l.key, "err", err)
+			l.readiness.set(err)
+			time.Sleep(leaderRetryInterval)
+			continue
+		}
+		atomic.StoreInt32(&l.leader, 1)
+		logger.Info("Acquired leader lock, collecting", "key", l.key)
+
+		<-lost
+		atomic.StoreInt32(&l.leader, 0)
+		logger.Warn("Lost leader lock, standing by", "key", l.key)
+		// Resets the lock, so that it can be acquired again.
+		l.lock.Unlock()
+	}
+}
+
+// attempt reads the lock before blocking on it. If another replica holds it,
+// this replica is ready to stand by.
+func (l *leaderElection) attempt() error {
+	pair, _, err := l.kv.Get(l.key, nil)
+	if err != nil {
+		return err
+	}
+	if pair != nil && pair.Session != "" {
+		l.readiness.set(nil)
+	}
+	return nil
+}
+
+// standby reports whether another replica holds the lock.
+func (l *leaderElection) standby() bool {
+	return l != nil && atomic.LoadInt32(&l.leader) == 0
+}
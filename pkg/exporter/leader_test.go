@@ -0,0 +1,75 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestStandby(t *testing.T) {
+	e, err := New(ConsulOpts{URI: "localhost:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.election = &leaderElection{key: "consul_exporter/leader"}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "consul_exporter_standby":
+			if v := mf.Metric[0].GetGauge().GetValue(); v != 1 {
+				t.Errorf("expected consul_exporter_standby 1, got %v", v)
+			}
+		case "consul_up":
+			t.Errorf("expected the standby not to query Consul")
+		}
+	}
+}
+
+func TestStandbyReady(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/session/create":
+			w.Write([]byte(`{"ID": "standby"}`))
+		case strings.HasPrefix(r.URL.Path, "/v1/session/"):
+			w.Write([]byte(`[{"ID": "standby"}]`))
+		case r.URL.Path == "/v1/kv/consul_exporter/leader":
+			if r.URL.Query().Get("index") != "" {
+				// Another replica keeps holding the lock.
+				select {
+				case <-r.Context().Done():
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+			w.Header().Set("X-Consul-Index", "5")
+			w.Write([]byte(`[{"Key": "consul_exporter/leader", "Flags": 3304740253564472344, "Session": "leader"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	e, err := New(ConsulOpts{URI: server.URL}, WithLeaderElection("consul_exporter/leader"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for e.Ready() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the standby to become ready, got %v", e.Ready())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !e.election.standby() {
+		t.Error("expected the replica to stand by")
+	}
+}
@@ -0,0 +1,15 @@
+package exporter
+
+import (
+	"log/slog"
+	"os"
+)
+
+// logger is the structured logger of the package.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// SetLogger sets the logger of the package, which logs to stderr in logfmt
+// by default.
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
@@ -0,0 +1,45 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// metricsFilter selects the metric families served by the metrics handler by
+// name. A nil filter serves all of them.
+type metricsFilter struct {
+	include, exclude *regexp.Regexp
+}
+
+// newMetricsFilter returns a filter serving the families whose name matches
+// include, if set, and doesn't match exclude, if set. The regexes are
+// anchored at both ends. It returns nil if neither is set.
+func newMetricsFilter(include, exclude string) (*metricsFilter, error) {
+	if include == "" && exclude == "" {
+		return nil, nil
+	}
+	f := &metricsFilter{}
+	var err error
+	if include != "" {
+		if f.include, err = regexp.Compile("^(?:" + include + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid metrics include regex: %s", err)
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile("^(?:" + exclude + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid metrics exclude regex: %s", err)
+		}
+	}
+	return f, nil
+}
+
+// allowed returns whether the family of the named metric is served.
+func (f *metricsFilter) allowed(name string) bool {
+	if f == nil {
+		return true
+	}
+	if f.include != nil && !f.include.MatchString(name) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(name)
+}
@@ -0,0 +1,27 @@
+package exporter
+
+import "testing"
+
+func TestMetricsFilter(t *testing.T) {
+	f, err := newMetricsFilter("consul_.*", "consul_health_(node|service)_status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{
+		"consul_up":                    true,
+		"consul_health_service_status": false,
+		"consul_health_node_status":    false,
+		"go_goroutines":                false,
+	} {
+		if got := f.allowed(name); got != expected {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+		}
+	}
+
+	if f, err := newMetricsFilter("", ""); err != nil || !f.allowed("go_goroutines") {
+		t.Errorf("expected no filter to allow all metrics, got %v", err)
+	}
+	if _, err := newMetricsFilter("(", ""); err == nil {
+		t.Error("expected an invalid regex to be rejected")
+	}
+}
@@ -0,0 +1,279 @@
+package exporter
+
+import "time"
+
+// Option configures an Exporter created by New.
+type Option func(*options)
+
+// options are the settings of an Exporter beyond the connection to Consul.
+type options struct {
+	kvPrefix        string
+	kvFilter        string
+	kvWatch         bool
+	kvTxn           bool
+	catalogWatch    bool
+	serviceWatch    bool
+	healthSummary   bool
+	maxServices     int
+	nodeMetaKeys    []string
+	serviceMetaKeys []string
+	tagLabels       bool
+	checksExclude   string
+	checkType       bool
+	checkLabel      string
+	checkStates     bool
+	breakdownTags   []string
+	token           string
+	nodesFilter     string
+	servicesFilter  string
+	healthFilter    string
+	includeKinds    []string
+	excludeKinds    []string
+	pluginDir       string
+	pluginTimeout   time.Duration
+	dnsAddr         string
+	dnsDomain       string
+	dnsServices     []string
+	workers         int
+	scrapeTimeout   time.Duration
+	dcStagger       time.Duration
+	dcSerial        bool
+	datacentersTTL  time.Duration
+	maxRPS          float64
+	agentCache      bool
+	cacheMaxAge     time.Duration
+	shardIndex      int
+	shardTotal      int
+	leaderKey       string
+	metricsInclude  string
+	metricsExclude  string
+	cfg             *Config
+}
+
+func defaultOptions() *options {
+	return &options{kvFilter: ".*", workers: defaultWorkers, datacentersTTL: defaultDatacentersTTL, dnsDomain: "consul"}
+}
+
+// WithKVPrefix exports the keys below prefix whose name matches the filter
+// regex.
+func WithKVPrefix(prefix, filter string) Option {
+	return func(o *options) {
+		o.kvPrefix = prefix
+		o.kvFilter = filter
+	}
+}
+
+// WithKVWatch watches the KV prefixes with blocking queries in the
+// background, scrapes serve the cached pairs.
+func WithKVWatch() Option {
+	return func(o *options) { o.kvWatch = true }
+}
+
+// WithKVTxn reads all KV prefixes in a single transaction. It is mutually
+// exclusive with WithKVWatch.
+func WithKVTxn() Option {
+	return func(o *options) { o.kvTxn = true }
+}
+
+// WithCatalogWatch watches the services, nodes and health checks of every
+// datacenter with blocking queries in the background, scrapes serve the
+// cached state.
+func WithCatalogWatch() Option {
+	return func(o *options) { o.catalogWatch = true }
+}
+
+// WithServiceHealthWatch watches the health of every service with a blocking
+// query per service instead of the health state of the whole datacenter,
+// which agents with use_streaming_backend serve from Consul's streaming
+// backend. It requires WithCatalogWatch and can't be combined with a health
+// filter.
+func WithServiceHealthWatch() Option {
+	return func(o *options) { o.serviceWatch = true }
+}
+
+// WithHealthSummary collects the health of every service instance, which
+// needs a query per service.
+func WithHealthSummary() Option {
+	return func(o *options) { o.healthSummary = true }
+}
+
+// WithMaxServices limits the number of services collected per datacenter,
+// 0 means unlimited.
+func WithMaxServices(n int) Option {
+	return func(o *options) { o.maxServices = n }
+}
+
+// WithMeta exports the given node and service metadata keys as labels.
+func WithMeta(nodeKeys, serviceKeys []string) Option {
+	return func(o *options) {
+		o.nodeMetaKeys = nodeKeys
+		o.serviceMetaKeys = serviceKeys
+	}
+}
+
+// WithServiceTagLabels adds the service_name and datacenter labels to
+// consul_service_tag, so that it can be joined with the health series. They
+// are opt-in to keep the label set of existing series.
+func WithServiceTagLabels() Option {
+	return func(o *options) { o.tagLabels = true }
+}
+
+// WithChecksExclude drops health checks whose ID matches the regex.
+func WithChecksExclude(regex string) Option {
+	return func(o *options) { o.checksExclude = regex }
+}
+
+// WithCheckType adds the check_type label, e.g. http, ttl or alias, to the
+// health check metrics.
+func WithCheckType() Option {
+	return func(o *options) { o.checkType = true }
+}
+
+// WithCheckLabel selects the identifier of health checks populating the check
+// label, CheckLabelID by default. Names are stable where orchestrators
+// generate a new ID whenever they reschedule a check.
+func WithCheckLabel(label string) Option {
+	return func(o *options) { o.checkLabel = label }
+}
+
+// WithCheckStateDuration tracks the status transitions of health checks in
+// memory and exports how long each has been in its current status and, for
+// critical checks with DeregisterCriticalServiceAfter, the time left until
+// Consul deregisters their service instance.
+func WithCheckStateDuration() Option {
+	return func(o *options) { o.checkStates = true }
+}
+
+// WithTagBreakdown counts all and the passing instances of every service for
+// each of the tags it carries, using one query per service and tag.
+func WithTagBreakdown(tags []string) Option {
+	return func(o *options) { o.breakdownTags = tags }
+}
+
+// WithToken sets the ACL token sent with all queries. Without it, the
+// CONSUL_HTTP_TOKEN environment variable is used.
+func WithToken(token string) Option {
+	return func(o *options) { o.token = token }
+}
+
+// WithFilters sets the filter expressions evaluated by Consul on the catalog
+// nodes, catalog services and health state queries. Empty expressions don't
+// filter.
+func WithFilters(nodes, services, health string) Option {
+	return func(o *options) {
+		o.nodesFilter = nodes
+		o.servicesFilter = services
+		o.healthFilter = health
+	}
+}
+
+// WithServiceKinds only collects services of the included kinds, if any,
+// and skips those of the excluded kinds. "typical" denotes regular services.
+func WithServiceKinds(include, exclude []string) Option {
+	return func(o *options) {
+		o.includeKinds = include
+		o.excludeKinds = exclude
+	}
+}
+
+// WithPlugins runs the executables in dir at every collection and merges the
+// metrics they print, in the text exposition format or as JSON array of
+// samples, into the output. They are killed after timeout, if positive.
+func WithPlugins(dir string, timeout time.Duration) Option {
+	return func(o *options) {
+		o.pluginDir = dir
+		o.pluginTimeout = timeout
+	}
+}
+
+// WithDNSProbe resolves the services through the DNS interface of the Consul
+// agent at addr, by default port 8600 of the Consul host, with the dns
+// collector. domain is the DNS domain of Consul, consul by default.
+func WithDNSProbe(addr, domain string, services []string) Option {
+	return func(o *options) {
+		o.dnsAddr = addr
+		if domain != "" {
+			o.dnsDomain = domain
+		}
+		o.dnsServices = services
+	}
+}
+
+// WithWorkers bounds the number of goroutines querying Consul concurrently
+// during collections, defaultWorkers by default. 0 means unbounded.
+func WithWorkers(n int) Option {
+	return func(o *options) { o.workers = n }
+}
+
+// WithDatacenterStagger spreads the start of the datacenters' collections
+// over window, with some jitter, instead of querying all of them at the start
+// of every collection. With serial, datacenters are collected one after the
+// other and window is ignored.
+func WithDatacenterStagger(window time.Duration, serial bool) Option {
+	return func(o *options) {
+		o.dcStagger = window
+		o.dcSerial = serial
+	}
+}
+
+// WithScrapeTimeout sets a deadline for whole collections. Collectors still
+// running after it are abandoned, so that the exporter responds in time even
+// if a datacenter hangs. 0 means no deadline.
+func WithScrapeTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.scrapeTimeout = timeout }
+}
+
+// WithDatacentersTTL caches the datacenters known to the catalog for ttl,
+// defaultDatacentersTTL by default. 0 queries them at every collection.
+func WithDatacentersTTL(ttl time.Duration) Option {
+	return func(o *options) { o.datacentersTTL = ttl }
+}
+
+// WithMaxRPS limits the requests to the Consul API to rps per second, shared
+// by all collectors and scrapes. Requests of watches aren't limited. 0 means
+// unlimited.
+func WithMaxRPS(rps float64) Option {
+	return func(o *options) { o.maxRPS = rps }
+}
+
+// WithAgentCache serves reads from the cache of the Consul agent, which
+// refreshes supported endpoints in the background. Entries older than maxAge,
+// if positive, are refetched from the servers. Consistent reads bypass the
+// cache.
+func WithAgentCache(maxAge time.Duration) Option {
+	return func(o *options) {
+		o.agentCache = true
+		o.cacheMaxAge = maxAge
+	}
+}
+
+// WithShard only collects the services and nodes whose name hashes to shard
+// index of total, so that total replicas split the catalog between them.
+func WithShard(index, total int) Option {
+	return func(o *options) {
+		o.shardIndex = index
+		o.shardTotal = total
+	}
+}
+
+// WithLeaderElection only collects while holding a lock on the KV key, so
+// that of several replicas sharing the key only one queries Consul. The
+// others only export consul_exporter_standby.
+func WithLeaderElection(key string) Option {
+	return func(o *options) { o.leaderKey = key }
+}
+
+// WithMetricsFilter only serves the metric families whose name matches the
+// include regex, if set, and doesn't match the exclude regex, if set. The
+// families are still collected.
+func WithMetricsFilter(include, exclude string) Option {
+	return func(o *options) {
+		o.metricsInclude = include
+		o.metricsExclude = exclude
+	}
+}
+
+// WithConfig applies the configuration file's settings.
+func WithConfig(cfg *Config) Option {
+	return func(o *options) { o.cfg = cfg }
+}
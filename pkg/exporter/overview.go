@@ -0,0 +1,127 @@
+package exporter
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// overview summarizes a snapshot for triage.
+type overview struct {
+	Time        time.Time
+	Leader      string
+	Peers       []string
+	Datacenters []datacenterOverview
+}
+
+type datacenterOverview struct {
+	Name     string
+	Nodes    int
+	Services int
+	// FailingChecks are the checks which aren't passing.
+	FailingChecks []*consul_api.HealthCheck
+	// UnhealthyServices are the services with checks, none of whose
+	// instances is passing.
+	UnhealthyServices []string
+}
+
+// overview summarizes the snapshot, s.mtx must be held.
+func (s *snapshot) overview() overview {
+	o := overview{Time: s.Time, Leader: s.Leader, Peers: s.Peers}
+	for name, dcs := range s.Datacenters {
+		dco := datacenterOverview{Name: name, Nodes: len(dcs.Nodes), Services: len(dcs.Services)}
+
+		type instance struct{ node, serviceID string }
+		nodeChecks := map[string]consul_api.HealthChecks{}
+		instanceChecks := map[instance]consul_api.HealthChecks{}
+		for _, hc := range dcs.Checks {
+			if hc.Status != consul_api.HealthPassing {
+				dco.FailingChecks = append(dco.FailingChecks, hc)
+			}
+			if hc.ServiceID == "" {
+				nodeChecks[hc.Node] = append(nodeChecks[hc.Node], hc)
+			} else {
+				i := instance{hc.Node, hc.ServiceID}
+				instanceChecks[i] = append(instanceChecks[i], hc)
+			}
+		}
+		// Like the health endpoint of a service, an instance is passing if
+		// all checks of the instance and its node are.
+		healthy := map[string]bool{}
+		for i, hcs := range instanceChecks {
+			name := hcs[0].ServiceName
+			passing := append(hcs, nodeChecks[i.node]...).AggregatedStatus() == consul_api.HealthPassing
+			healthy[name] = healthy[name] || passing
+		}
+		for name, ok := range healthy {
+			if !ok {
+				dco.UnhealthyServices = append(dco.UnhealthyServices, name)
+			}
+		}
+		sort.Strings(dco.UnhealthyServices)
+		sort.Slice(dco.FailingChecks, func(i, j int) bool {
+			a, b := dco.FailingChecks[i], dco.FailingChecks[j]
+			if a.Node != b.Node {
+				return a.Node < b.Node
+			}
+			return a.CheckID < b.CheckID
+		})
+		o.Datacenters = append(o.Datacenters, dco)
+	}
+	sort.Slice(o.Datacenters, func(i, j int) bool { return o.Datacenters[i].Name < o.Datacenters[j].Name })
+	return o
+}
+
+var overviewTemplate = template.Must(template.New("overview").Parse(`<html>
+<head><title>Consul Overview</title></head>
+<body>
+<h1>Consul Overview</h1>
+<p>Collected at {{.Time.Format "2006-01-02 15:04:05 MST"}}</p>
+<p>Leader: {{if .Leader}}{{.Leader}}{{else}}<strong>none</strong>{{end}}, peers: {{range $i, $p := .Peers}}{{if $i}}, {{end}}{{$p}}{{end}}</p>
+{{range .Datacenters}}
+<h2>{{.Name}}</h2>
+<p>{{.Nodes}} nodes, {{.Services}} services</p>
+{{if .UnhealthyServices}}
+<h3>Services without healthy instances</h3>
+<ul>{{range .UnhealthyServices}}<li>{{.}}</li>{{end}}</ul>
+{{end}}
+{{if .FailingChecks}}
+<h3>Failing checks</h3>
+<table border="1" cellpadding="4">
+<tr><th>Node</th><th>Check</th><th>Service</th><th>Status</th><th>Output</th></tr>
+{{range .FailingChecks}}<tr><td>{{.Node}}</td><td>{{.CheckID}}</td><td>{{.ServiceName}}</td><td>{{.Status}}</td><td><pre>{{.Output}}</pre></td></tr>
+{{end}}</table>
+{{else}}
+<p>All checks are passing.</p>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// OverviewHandler returns a handler rendering the state seen by the last
+// full collection as a read-only HTML page: the datacenters, the Raft
+// leader, the failing checks and the services without healthy instances.
+func (e *Exporter) OverviewHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := e.snapshots
+		st.mtx.RLock()
+		last := st.last
+		st.mtx.RUnlock()
+		if last == nil {
+			http.Error(w, "No successful collection yet.", http.StatusServiceUnavailable)
+			return
+		}
+
+		last.mtx.Lock()
+		o := last.overview()
+		last.mtx.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := overviewTemplate.Execute(w, o); err != nil {
+			logger.Error("Error rendering overview", "err", err)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+func TestSnapshotOverview(t *testing.T) {
+	s := newSnapshot([]string{"10.0.0.1:8300"})
+	s.setServices("dc1", map[string][]string{"web": nil, "db": nil, "cache": nil})
+	for _, hc := range []*consul_api.HealthCheck{
+		{Node: "node1", CheckID: "serfHealth", Status: consul_api.HealthPassing},
+		{Node: "node2", CheckID: "serfHealth", Status: consul_api.HealthCritical},
+		{Node: "node1", CheckID: "web", ServiceID: "web1", ServiceName: "web", Status: consul_api.HealthPassing},
+		{Node: "node2", CheckID: "web", ServiceID: "web2", ServiceName: "web", Status: consul_api.HealthPassing},
+		{Node: "node2", CheckID: "db", ServiceID: "db1", ServiceName: "db", Status: consul_api.HealthPassing},
+		{Node: "node1", CheckID: "cache", ServiceID: "cache1", ServiceName: "cache", Status: consul_api.HealthWarning},
+	} {
+		s.addCheck("dc1", hc)
+	}
+
+	o := s.overview()
+	if len(o.Datacenters) != 1 {
+		t.Fatalf("expected 1 datacenter, got %d", len(o.Datacenters))
+	}
+	dc := o.Datacenters[0]
+	// db's only instance is on a failed node, web has a healthy one.
+	if want := []string{"cache", "db"}; !reflect.DeepEqual(dc.UnhealthyServices, want) {
+		t.Errorf("expected unhealthy services %v, got %v", want, dc.UnhealthyServices)
+	}
+	if len(dc.FailingChecks) != 2 || dc.Services != 3 {
+		t.Errorf("expected 2 failing checks of 3 services, got %d of %d", len(dc.FailingChecks), dc.Services)
+	}
+}
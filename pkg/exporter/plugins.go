@@ -0,0 +1,261 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func init() {
+	registerCollector(collectorPlugins, pluginsCollector{}, true)
+}
+
+// pluginsCollector runs the executables of the plugin directory and merges
+// the metrics they print into the output. It does nothing without a plugin
+// directory.
+type pluginsCollector struct{}
+
+func (pluginsCollector) collect(s *scrape, ch chan<- prometheus.Metric) {
+	e := s.e
+	if e.plugins == nil {
+		return
+	}
+	defer e.observe(ch, collectorPlugins, "", time.Now())
+
+	paths, err := e.plugins.list()
+	if err != nil {
+		logger.Error("Can't list plugins", "dir", e.plugins.dir, "err", err)
+		e.failures.addCollector(collectorPlugins)
+		return
+	}
+
+	e.pool.each(len(paths), func(i int) {
+		path := paths[i]
+		name := filepath.Base(path)
+		mfs, err := e.plugins.run(s.ctx, path)
+		if err != nil {
+			logger.Error("Plugin failed", "plugin", name, "err", err)
+			e.failures.addCollector(collectorPlugins)
+			ch <- prometheus.MustNewConstMetric(pluginUp, prometheus.GaugeValue, 0, name)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(pluginUp, prometheus.GaugeValue, 1, name)
+		for _, mf := range mfs {
+			for _, m := range e.plugins.metrics(mf) {
+				ch <- m
+			}
+		}
+	})
+}
+
+// plugins runs the executables of a directory, passing them the address and
+// credentials of Consul in the environment variables of the Consul CLI.
+type plugins struct {
+	dir     string
+	timeout time.Duration
+	env     []string
+
+	mtx   sync.Mutex
+	descs map[string]*prometheus.Desc
+}
+
+func newPlugins(dir string, timeout time.Duration, env []string) *plugins {
+	return &plugins{dir: dir, timeout: timeout, env: env, descs: map[string]*prometheus.Desc{}}
+}
+
+// list returns the paths of the executable regular files in the directory,
+// in lexicographical order.
+func (p *plugins) list() ([]string, error) {
+	infos, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() && fi.Mode().Perm()&0111 != 0 {
+			paths = append(paths, filepath.Join(p.dir, fi.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// run executes the plugin at path and parses its output, which is either the
+// text exposition format or a JSON array of samples.
+func (p *plugins) run(ctx context.Context, path string) ([]*dto.MetricFamily, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(), p.env...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+	return parsePluginOutput(stdout.Bytes())
+}
+
+// pluginSample is a sample of the JSON output of a plugin.
+type pluginSample struct {
+	Name   string            `json:"name"`
+	Help   string            `json:"help"`
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+var pluginMetricTypes = map[string]dto.MetricType{
+	"":        dto.MetricType_UNTYPED,
+	"untyped": dto.MetricType_UNTYPED,
+	"gauge":   dto.MetricType_GAUGE,
+	"counter": dto.MetricType_COUNTER,
+}
+
+// parsePluginOutput parses the output of a plugin into metric families.
+func parsePluginOutput(out []byte) ([]*dto.MetricFamily, error) {
+	if trimmed := bytes.TrimSpace(out); len(trimmed) == 0 || trimmed[0] != '[' {
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(bytes.NewReader(out))
+		if err != nil {
+			return nil, err
+		}
+		mfs := make([]*dto.MetricFamily, 0, len(families))
+		for _, mf := range families {
+			mfs = append(mfs, mf)
+		}
+		return mfs, nil
+	}
+
+	var samples []pluginSample
+	if err := json.Unmarshal(out, &samples); err != nil {
+		return nil, err
+	}
+	families := map[string]*dto.MetricFamily{}
+	var mfs []*dto.MetricFamily
+	for _, s := range samples {
+		t, ok := pluginMetricTypes[s.Type]
+		if !ok {
+			return nil, fmt.Errorf("unsupported type %q of metric %s", s.Type, s.Name)
+		}
+		mf, ok := families[s.Name]
+		if !ok {
+			name, help := s.Name, s.Help
+			mf = &dto.MetricFamily{Name: &name, Help: &help, Type: &t}
+			families[s.Name] = mf
+			mfs = append(mfs, mf)
+		}
+		m := &dto.Metric{}
+		for ln, lv := range s.Labels {
+			ln, lv := ln, lv
+			m.Label = append(m.Label, &dto.LabelPair{Name: &ln, Value: &lv})
+		}
+		value := s.Value
+		switch t {
+		case dto.MetricType_GAUGE:
+			m.Gauge = &dto.Gauge{Value: &value}
+		case dto.MetricType_COUNTER:
+			m.Counter = &dto.Counter{Value: &value}
+		default:
+			m.Untyped = &dto.Untyped{Value: &value}
+		}
+		mf.Metric = append(mf.Metric, m)
+	}
+	return mfs, nil
+}
+
+// metrics converts a metric family printed by a plugin to constant metrics.
+// Histograms and summaries are skipped.
+func (p *plugins) metrics(mf *dto.MetricFamily) []prometheus.Metric {
+	var valueType prometheus.ValueType
+	switch mf.GetType() {
+	case dto.MetricType_GAUGE:
+		valueType = prometheus.GaugeValue
+	case dto.MetricType_COUNTER:
+		valueType = prometheus.CounterValue
+	case dto.MetricType_UNTYPED:
+		valueType = prometheus.UntypedValue
+	default:
+		logger.Debug("Skipping plugin metric of unsupported type", "metric", mf.GetName(), "type", mf.GetType())
+		return nil
+	}
+
+	metrics := make([]prometheus.Metric, 0, len(mf.Metric))
+	for _, pb := range mf.Metric {
+		labelNames := make([]string, len(pb.Label))
+		labelValues := make([]string, len(pb.Label))
+		for i, lp := range pb.Label {
+			labelNames[i], labelValues[i] = lp.GetName(), lp.GetValue()
+		}
+		value := pb.GetUntyped().GetValue()
+		switch valueType {
+		case prometheus.GaugeValue:
+			value = pb.GetGauge().GetValue()
+		case prometheus.CounterValue:
+			value = pb.GetCounter().GetValue()
+		}
+
+		m, err := prometheus.NewConstMetric(p.desc(mf.GetName(), mf.GetHelp(), labelNames), valueType, value, labelValues...)
+		if err != nil {
+			logger.Error("Invalid plugin metric", "metric", mf.GetName(), "err", err)
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// desc returns a cached descriptor for a plugin metric. Descriptors are
+// created with newDesc, so that plugin metrics can be relabeled as well.
+func (p *plugins) desc(name, help string, labelNames []string) *prometheus.Desc {
+	key := name + "\xff" + help + "\xff" + strings.Join(labelNames, "\xff")
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	desc, ok := p.descs[key]
+	if !ok {
+		desc = newDesc(name, help, labelNames)
+		p.descs[key] = desc
+	}
+	return desc
+}
+
+// consulEnv returns the environment variables of the Consul CLI pointing to
+// the Consul the exporter queries.
+func consulEnv(opts ConsulOpts, uri, token string) []string {
+	env := []string{"CONSUL_HTTP_ADDR=" + uri}
+	for name, value := range map[string]string{
+		"CONSUL_HTTP_TOKEN":      token,
+		"CONSUL_CACERT":          opts.CAFile,
+		"CONSUL_CLIENT_CERT":     opts.CertFile,
+		"CONSUL_CLIENT_KEY":      opts.KeyFile,
+		"CONSUL_TLS_SERVER_NAME": opts.ServerName,
+	} {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
@@ -0,0 +1,58 @@
+package exporter
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePluginOutput(t *testing.T) {
+	mfs, err := parsePluginOutput([]byte(`[
+		{"name": "site_quota", "type": "gauge", "labels": {"team": "a"}, "value": 3},
+		{"name": "site_quota", "type": "gauge", "labels": {"team": "b"}, "value": 4}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 1 || len(mfs[0].Metric) != 2 || mfs[0].Metric[1].GetGauge().GetValue() != 4 {
+		t.Errorf("unexpected metric families %v", mfs)
+	}
+
+	if _, err := parsePluginOutput([]byte(`[{"name": "site_latency", "type": "histogram"}]`)); err == nil {
+		t.Errorf("expected error for unsupported type")
+	}
+}
+
+func TestPluginsRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := "#!/bin/sh\necho \"site_addr{addr=\\\"$CONSUL_HTTP_ADDR\\\"} 1\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "addr"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newPlugins(dir, 0, consulEnv(ConsulOpts{}, "http://localhost:8500", ""))
+	paths, err := p.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("expected only the executable to be listed, got %v", paths)
+	}
+	mfs, err := p.run(context.Background(), paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 1 || mfs[0].Metric[0].Label[0].GetValue() != "http://localhost:8500" {
+		t.Errorf("unexpected metric families %v", mfs)
+	}
+}
@@ -0,0 +1,50 @@
+package exporter
+
+import "sync"
+
+// defaultWorkers is the default size of the worker pool.
+const defaultWorkers = 32
+
+// workerPool bounds the number of goroutines querying Consul, shared by all
+// collections of an exporter. A nil pool is unbounded.
+type workerPool struct {
+	workers chan struct{}
+}
+
+func newWorkerPool(size int) *workerPool {
+	if size <= 0 {
+		return nil
+	}
+	return &workerPool{workers: make(chan struct{}, size)}
+}
+
+// each runs f for 0 <= i < n concurrently and waits for all of them. When
+// all workers are busy, f runs in the calling goroutine instead, so that
+// nested fan-outs can't deadlock waiting for each other's workers.
+func (p *workerPool) each(n int, f func(i int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		if p == nil {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				f(i)
+			}(i)
+			continue
+		}
+		select {
+		case p.workers <- struct{}{}:
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-p.workers
+					wg.Done()
+				}()
+				f(i)
+			}(i)
+		default:
+			f(i)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,45 @@
+package exporter
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestWorkerPool(t *testing.T) {
+	p := newWorkerPool(2)
+
+	var (
+		mtx     sync.Mutex
+		running int
+		peak    int
+		done    = make([]bool, 20)
+	)
+	// Nested fan-outs must complete even though the outer one occupies
+	// all workers.
+	p.each(4, func(i int) {
+		p.each(5, func(j int) {
+			mtx.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mtx.Unlock()
+
+			done[i*5+j] = true
+
+			mtx.Lock()
+			running--
+			mtx.Unlock()
+		})
+	})
+
+	for i, ok := range done {
+		if !ok {
+			t.Errorf("task %d didn't run", i)
+		}
+	}
+	// Two workers and the calling goroutine.
+	if peak > 3 {
+		t.Errorf("expected at most 3 concurrent tasks, got %d", peak)
+	}
+}
@@ -0,0 +1,167 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// defaultProbeTimeout is the timeout of a probe without its own.
+const defaultProbeTimeout = 5 * time.Second
+
+func init() {
+	registerCollector(collectorProbe, probeCollector{}, true)
+}
+
+// ProbeConfig configures the probe of a service. A passing instance of the
+// service is picked from the health endpoint of the local datacenter and
+// probed, which catches instances Consul considers healthy but clients can't
+// reach.
+type ProbeConfig struct {
+	// Type is tcp, connecting to the instance, or http, expecting a 2xx or
+	// 3xx response. tcp by default.
+	Type string `hcl:"type"`
+	// Tag restricts the probed instances to those with the tag.
+	Tag string `hcl:"tag"`
+	// Scheme and Path of http probes, http and / by default.
+	Scheme        string `hcl:"scheme"`
+	Path          string `hcl:"path"`
+	TLSSkipVerify bool   `hcl:"tls_skip_verify"`
+	Timeout       string `hcl:"timeout"`
+
+	timeout time.Duration
+}
+
+// init validates the probe configuration and applies the defaults.
+func (pc *ProbeConfig) init() (err error) {
+	switch pc.Type {
+	case "":
+		pc.Type = "tcp"
+	case "tcp", "http":
+	default:
+		return fmt.Errorf("unknown type %q", pc.Type)
+	}
+	switch pc.Scheme {
+	case "":
+		pc.Scheme = "http"
+	case "http", "https":
+	default:
+		return fmt.Errorf("unknown scheme %q", pc.Scheme)
+	}
+	if pc.Path == "" {
+		pc.Path = "/"
+	}
+	pc.timeout = defaultProbeTimeout
+	if pc.Timeout != "" {
+		if pc.timeout, err = time.ParseDuration(pc.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout: %s", err)
+		}
+	}
+	return nil
+}
+
+// probeCollector probes a passing instance of each configured service. It
+// does nothing without probes.
+type probeCollector struct{}
+
+func (probeCollector) collect(s *scrape, ch chan<- prometheus.Metric) {
+	e := s.e
+	if len(e.probes) == 0 {
+		return
+	}
+	defer e.observe(ch, collectorProbe, "", time.Now())
+
+	services := make([]string, 0, len(e.probes))
+	for service := range e.probes {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	e.pool.each(len(services), func(i int) {
+		service := services[i]
+		pc := e.probes[service]
+		opts, cancel := e.queryOptions(s.ctx, "", EndpointHealth)
+		defer cancel()
+		entries, _, err := e.client.Health().Service(service, pc.Tag, true, opts)
+		if err != nil {
+			e.collectorError(collectorProbe, "/v1/health/service", "", err, "service", service)
+			return
+		}
+		if len(entries) == 0 {
+			logger.Warn("No passing instance to probe", "service", service)
+			ch <- prometheus.MustNewConstMetric(probeSuccess, prometheus.GaugeValue, 0, service)
+			return
+		}
+
+		addr := instanceAddress(entries[rand.Intn(len(entries))])
+		start := time.Now()
+		err = pc.probe(s.ctx, addr)
+		if s.ctx.Err() != nil {
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(probeDuration, prometheus.GaugeValue, time.Since(start).Seconds(), service)
+		if err != nil {
+			logger.Warn("Probe failed", "service", service, "address", addr, "err", err)
+			ch <- prometheus.MustNewConstMetric(probeSuccess, prometheus.GaugeValue, 0, service)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(probeSuccess, prometheus.GaugeValue, 1, service)
+	})
+}
+
+// instanceAddress returns the address of a service instance, which defaults
+// to that of its node.
+func instanceAddress(entry *consul_api.ServiceEntry) string {
+	host := entry.Service.Address
+	if host == "" {
+		host = entry.Node.Address
+	}
+	return net.JoinHostPort(host, strconv.Itoa(entry.Service.Port))
+}
+
+// probe connects to addr or, for http probes, requests its path.
+func (pc *ProbeConfig) probe(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, pc.timeout)
+	defer cancel()
+
+	if pc.Type == "tcp" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequest("GET", pc.Scheme+"://"+addr+pc.Path, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: pc.TLSSkipVerify},
+			DisableKeepAlives: true,
+		},
+		// Redirects may point to hosts clients of the service can't reach.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
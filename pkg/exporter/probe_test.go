@@ -0,0 +1,39 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	for _, tc := range []struct {
+		pc ProbeConfig
+		ok bool
+	}{
+		{ProbeConfig{}, true},
+		{ProbeConfig{Type: "http", Path: "/healthz"}, true},
+		{ProbeConfig{Type: "http", Path: "/missing"}, false},
+	} {
+		if err := tc.pc.init(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tc.pc.probe(context.Background(), addr); (err == nil) != tc.ok {
+			t.Errorf("%s probe of %s: expected success %v, got %v", tc.pc.Type, tc.pc.Path, tc.ok, err)
+		}
+	}
+
+	if err := (&ProbeConfig{Type: "icmp"}).init(); err == nil {
+		t.Error("expected an unknown type to be rejected")
+	}
+}
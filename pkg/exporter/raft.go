@@ -0,0 +1,40 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector(collectorRaft, raftCollector{}, true)
+}
+
+// raftCollector collects the peers and leader of the Raft cluster.
+type raftCollector struct{}
+
+func (raftCollector) collect(s *scrape, ch chan<- prometheus.Metric) {
+	e := s.e
+	defer e.observe(ch, collectorRaft, "", time.Now())
+
+	if s.peersErr == nil {
+		ch <- prometheus.MustNewConstMetric(
+			clusterServers, prometheus.GaugeValue, float64(len(s.peers)),
+		)
+	}
+
+	var leader string
+	if err := e.rawQuery(s.ctx, "/v1/status/leader", &leader); err != nil {
+		e.queryError("/v1/status/leader", "", err)
+	}
+	e.snapshot.setLeader(leader)
+	if len(leader) == 0 {
+		ch <- prometheus.MustNewConstMetric(
+			clusterLeader, prometheus.GaugeValue, 0,
+		)
+	} else {
+		ch <- prometheus.MustNewConstMetric(
+			clusterLeader, prometheus.GaugeValue, 1,
+		)
+	}
+}
@@ -0,0 +1,75 @@
+package exporter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting the rate of requests to the Consul
+// API, shared by all collectors and scrapes. Its methods are safe to call on
+// a nil value, which doesn't limit.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mtx    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter allowing rps requests per second, with
+// bursts of up to one second's worth of requests.
+func newRateLimiter(rps float64) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	burst := math.Max(1, math.Ceil(rps))
+	return &rateLimiter{rate: rps, burst: burst, tokens: burst}
+}
+
+// reserve takes a token and returns how long to wait until it is available.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// release gives back a token which wasn't used.
+func (l *rateLimiter) release() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.tokens = math.Min(l.burst, l.tokens+1)
+}
+
+// wait blocks until a request may be sent or the context is done. A canceled
+// wait gives its token back.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	delay := l.reserve(time.Now())
+	if delay == 0 {
+		return nil
+	}
+	rateLimitWait.Add(delay.Seconds())
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		l.release()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Error("expected no limiter without a rate")
+	}
+
+	l := newRateLimiter(2)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if d := l.reserve(now); d != 0 {
+			t.Errorf("expected request %d of the burst not to wait, got %s", i, d)
+		}
+	}
+	if d := l.reserve(now); d != 500*time.Millisecond {
+		t.Errorf("expected third request to wait 500ms, got %s", d)
+	}
+	// The waiting request used up the refilled token.
+	if d := l.reserve(now.Add(500 * time.Millisecond)); d != 500*time.Millisecond {
+		t.Errorf("expected fourth request to wait 500ms, got %s", d)
+	}
+}
+
+func TestRateLimiterCanceledWait(t *testing.T) {
+	l := newRateLimiter(1)
+	l.reserve(time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx); err != context.Canceled {
+		t.Fatalf("expected the wait to be canceled, got %v", err)
+	}
+	// The canceled wait gave its token back.
+	if d := l.reserve(time.Now()); d > time.Second {
+		t.Errorf("expected to wait at most 1s, got %s", d)
+	}
+}
+
+func TestRateLimitLongerThanTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/status/leader":
+			w.Write([]byte(`"10.0.0.1:8300"`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	e, err := New(ConsulOpts{URI: server.URL, Timeout: 100 * time.Millisecond}, WithMaxRPS(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// After the burst, throttling delays requests by up to 500ms, longer
+	// than their timeout, both with the client's and the query's timeout.
+	for i := 0; i < 6; i++ {
+		if _, err := e.client.Status().Leader(); err != nil {
+			t.Fatalf("expected request %d to be delayed rather than fail, got %v", i, err)
+		}
+		opts, cancel := e.queryOptions(context.Background(), "dc1", EndpointCatalog)
+		_, _, err := e.client.Catalog().Nodes(opts)
+		cancel()
+		if err != nil {
+			t.Fatalf("expected query %d to be delayed rather than fail, got %v", i, err)
+		}
+	}
+}
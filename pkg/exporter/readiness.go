@@ -0,0 +1,43 @@
+package exporter
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+var errNotCollected = errors.New("no collection yet")
+
+// readiness tracks whether the last collection could reach Consul.
+type readiness struct {
+	mtx sync.RWMutex
+	err error
+}
+
+func newReadiness() *readiness {
+	return &readiness{err: errNotCollected}
+}
+
+// set records the outcome of querying Consul, nil meaning it was reachable.
+func (r *readiness) set(err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.err = err
+}
+
+// ready returns nil if the last collection reached Consul.
+func (r *readiness) ready() error {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.err
+}
+
+// ServeHTTP responds with 200 if Consul was reachable during the last
+// collection and 503 otherwise.
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := r.ready(); err != nil {
+		http.Error(w, "Not ready: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("Ready.\n"))
+}
@@ -0,0 +1,58 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// redacted replaces sensitive keys in logs.
+const redacted = "[redacted]"
+
+// redactor keeps KV pairs whose key matches a sensitive pattern out of logs,
+// errors and the snapshot endpoint. Its methods are safe to call on a nil
+// value, which treats no key as sensitive.
+type redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// newRedactor compiles the patterns, which are anchored at both ends like
+// allowlists. It returns nil without patterns.
+func newRedactor(patterns []string) (*redactor, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	r := &redactor{}
+	for _, p := range patterns {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid kv_sensitive pattern: %s", err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// sensitive reports whether the key matches a sensitive pattern.
+func (r *redactor) sensitive(key string) bool {
+	if r == nil {
+		return false
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// logArgs returns the log attributes for a problem with the value of key.
+// The error is omitted for sensitive keys, as parse errors quote the value.
+func (r *redactor) logArgs(key string, err error) []interface{} {
+	if r.sensitive(key) {
+		return []interface{}{"key", redacted}
+	}
+	if err == nil {
+		return []interface{}{"key", key}
+	}
+	return []interface{}{"key", key, "err", err}
+}
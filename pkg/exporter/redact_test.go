@@ -0,0 +1,34 @@
+package exporter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	var none *redactor
+	if none.sensitive("config/db/password") {
+		t.Errorf("expected no sensitive keys without patterns")
+	}
+
+	r, err := newRedactor([]string{"config/.*/password", "secrets/.*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.sensitive("config/db/password") || r.sensitive("config/db/password_length") {
+		t.Errorf("expected patterns to be anchored")
+	}
+
+	parseErr := errors.New(`strconv.ParseFloat: parsing "hunter2": invalid syntax`)
+	if args := r.logArgs("secrets/api", parseErr); !reflect.DeepEqual(args, []interface{}{"key", redacted}) {
+		t.Errorf("expected key and error to be redacted, got %v", args)
+	}
+	if args := r.logArgs("config/replicas", parseErr); len(args) != 4 {
+		t.Errorf("expected key and error of non-sensitive key, got %v", args)
+	}
+
+	if _, err := newRedactor([]string{"("}); err == nil {
+		t.Errorf("expected error for invalid pattern")
+	}
+}
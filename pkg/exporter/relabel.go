@@ -0,0 +1,264 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	relabelReplace = "replace"
+	relabelKeep    = "keep"
+	relabelDrop    = "drop"
+
+	// metricNameLabel is the pseudo label holding the metric name during
+	// relabeling.
+	metricNameLabel = "__name__"
+)
+
+// RelabelConfig describes a single relabeling step, following the semantics
+// of Prometheus' metric_relabel_configs.
+type RelabelConfig struct {
+	SourceLabels []string `hcl:"source_labels"`
+	Separator    string   `hcl:"separator"`
+	Regex        string   `hcl:"regex"`
+	TargetLabel  string   `hcl:"target_label"`
+	Replacement  string   `hcl:"replacement"`
+	Action       string   `hcl:"action"`
+
+	regex *regexp.Regexp
+}
+
+// init applies the defaults and compiles the regex.
+func (rc *RelabelConfig) init() error {
+	if rc.Separator == "" {
+		rc.Separator = ";"
+	}
+	if rc.Regex == "" {
+		rc.Regex = "(.*)"
+	}
+	if rc.Replacement == "" {
+		rc.Replacement = "$1"
+	}
+	if rc.Action == "" {
+		rc.Action = relabelReplace
+	}
+
+	switch rc.Action {
+	case relabelReplace:
+		if rc.TargetLabel == "" {
+			return fmt.Errorf("target_label is required for action %q", rc.Action)
+		}
+	case relabelKeep, relabelDrop:
+	default:
+		return fmt.Errorf("unknown action %q", rc.Action)
+	}
+
+	re, err := regexp.Compile("^(?:" + rc.Regex + ")$")
+	if err != nil {
+		return err
+	}
+	rc.regex = re
+	return nil
+}
+
+// NamespaceRelabelConfig returns a config replacing the namespace of all
+// metric names with ns.
+func NamespaceRelabelConfig(ns string) (*RelabelConfig, error) {
+	rc := &RelabelConfig{
+		SourceLabels: []string{metricNameLabel},
+		Regex:        Namespace + "_(.*)",
+		TargetLabel:  metricNameLabel,
+		Replacement:  strings.TrimSuffix(ns, "_") + "_${1}",
+	}
+	return rc, rc.init()
+}
+
+// relabel applies the configs to the given label set in order. It returns nil
+// if the series is to be dropped.
+func relabel(labels map[string]string, cfgs []*RelabelConfig) map[string]string {
+	for _, rc := range cfgs {
+		values := make([]string, len(rc.SourceLabels))
+		for i, name := range rc.SourceLabels {
+			values[i] = labels[name]
+		}
+		value := strings.Join(values, rc.Separator)
+
+		switch rc.Action {
+		case relabelKeep:
+			if !rc.regex.MatchString(value) {
+				return nil
+			}
+		case relabelDrop:
+			if rc.regex.MatchString(value) {
+				return nil
+			}
+		case relabelReplace:
+			indexes := rc.regex.FindStringSubmatchIndex(value)
+			if indexes == nil {
+				continue
+			}
+			target := string(rc.regex.ExpandString(nil, rc.Replacement, value, indexes))
+			if target == "" {
+				delete(labels, rc.TargetLabel)
+				continue
+			}
+			labels[rc.TargetLabel] = target
+		}
+	}
+	return labels
+}
+
+// descMeta holds the parts of a metric descriptor that relabeling needs but
+// prometheus.Desc doesn't expose.
+type descMeta struct {
+	name       string
+	help       string
+	labelNames []string
+}
+
+var (
+	descMetasMtx sync.RWMutex
+	descMetas    = map[*prometheus.Desc]descMeta{}
+)
+
+// newDesc works like prometheus.NewDesc, but records the descriptor so that
+// metrics using it can be relabeled.
+func newDesc(name, help string, labelNames []string) *prometheus.Desc {
+	desc := prometheus.NewDesc(name, help, labelNames, nil)
+
+	descMetasMtx.Lock()
+	defer descMetasMtx.Unlock()
+	descMetas[desc] = descMeta{name: name, help: help, labelNames: labelNames}
+	return desc
+}
+
+// recordDescs records the descriptor of the metric vector or metric c, named
+// name, so that its metrics can be relabeled like those of newDesc.
+func recordDescs(c prometheus.Collector, name, help string, labelNames []string) {
+	descs := make(chan *prometheus.Desc, 1)
+	c.Describe(descs)
+	close(descs)
+
+	descMetasMtx.Lock()
+	defer descMetasMtx.Unlock()
+	for desc := range descs {
+		descMetas[desc] = descMeta{name: name, help: help, labelNames: labelNames}
+	}
+}
+
+// relabeler rewrites collected metrics according to the relabel configs.
+type relabeler struct {
+	cfgs []*RelabelConfig
+
+	mtx   sync.Mutex
+	descs map[string]*prometheus.Desc
+}
+
+func newRelabeler(cfgs []*RelabelConfig) *relabeler {
+	return &relabeler{
+		cfgs:  cfgs,
+		descs: map[string]*prometheus.Desc{},
+	}
+}
+
+// relabelMetric returns the relabeled metric or nil if it was dropped.
+func (r *relabeler) relabelMetric(m prometheus.Metric) prometheus.Metric {
+	descMetasMtx.RLock()
+	meta, ok := descMetas[m.Desc()]
+	descMetasMtx.RUnlock()
+	if !ok {
+		return m
+	}
+
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		logger.Error("Can't relabel metric", "metric", meta.name, "err", err)
+		return nil
+	}
+
+	labels := make(map[string]string, len(pb.Label)+1)
+	for _, lp := range pb.Label {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+	labels[metricNameLabel] = meta.name
+
+	labels = relabel(labels, r.cfgs)
+	if labels == nil {
+		return nil
+	}
+
+	name := labels[metricNameLabel]
+	delete(labels, metricNameLabel)
+	labelNames := make([]string, 0, len(labels))
+	for ln := range labels {
+		labelNames = append(labelNames, ln)
+	}
+	sort.Strings(labelNames)
+	labelValues := make([]string, len(labelNames))
+	for i, ln := range labelNames {
+		labelValues[i] = labels[ln]
+	}
+
+	desc := r.desc(name, meta.help, labelNames)
+	var (
+		metric prometheus.Metric
+		err    error
+	)
+	if h := pb.Histogram; h != nil {
+		buckets := make(map[float64]uint64, len(h.Bucket))
+		for _, b := range h.Bucket {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+		metric, err = prometheus.NewConstHistogram(desc, h.GetSampleCount(), h.GetSampleSum(), buckets, labelValues...)
+	} else {
+		valueType, value := prometheus.UntypedValue, pb.GetUntyped().GetValue()
+		switch {
+		case pb.Gauge != nil:
+			valueType, value = prometheus.GaugeValue, pb.GetGauge().GetValue()
+		case pb.Counter != nil:
+			valueType, value = prometheus.CounterValue, pb.GetCounter().GetValue()
+		}
+		metric, err = prometheus.NewConstMetric(desc, valueType, value, labelValues...)
+	}
+	if err != nil {
+		logger.Error("Can't relabel metric", "metric", meta.name, "err", err)
+		return nil
+	}
+	if pb.TimestampMs != nil {
+		metric = timestampedMetric{Metric: metric, t: time.Unix(0, pb.GetTimestampMs()*int64(time.Millisecond))}
+	}
+	return metric
+}
+
+// desc returns a cached descriptor for the relabeled metric.
+func (r *relabeler) desc(name, help string, labelNames []string) *prometheus.Desc {
+	size := len(name)
+	for _, ln := range labelNames {
+		size += len(ln) + 1
+	}
+	var b strings.Builder
+	b.Grow(size)
+	b.WriteString(name)
+	for _, ln := range labelNames {
+		b.WriteByte('\xff')
+		b.WriteString(ln)
+	}
+	key := b.String()
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	desc, ok := r.descs[key]
+	if !ok {
+		desc = prometheus.NewDesc(name, help, labelNames, nil)
+		r.descs[key] = desc
+	}
+	return desc
+}
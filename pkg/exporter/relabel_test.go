@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRelabel(t *testing.T) {
+	cases := []struct {
+		cfgs []*RelabelConfig
+		in   map[string]string
+		out  map[string]string
+	}{
+		{
+			cfgs: []*RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "consul_(.*)", TargetLabel: "__name__", Replacement: "dc1_${1}"}},
+			in:   map[string]string{"__name__": "consul_up"},
+			out:  map[string]string{"__name__": "dc1_up"},
+		},
+		{
+			cfgs: []*RelabelConfig{{SourceLabels: []string{"check"}, Regex: "serfHealth", Action: "drop"}},
+			in:   map[string]string{"__name__": "consul_health_node_status", "check": "serfHealth"},
+			out:  nil,
+		},
+		{
+			cfgs: []*RelabelConfig{{SourceLabels: []string{"service_name"}, Regex: "web", Action: "keep"}},
+			in:   map[string]string{"service_name": "web"},
+			out:  map[string]string{"service_name": "web"},
+		},
+		{
+			cfgs: []*RelabelConfig{{SourceLabels: []string{"tags"}, Regex: ".*", TargetLabel: "tags", Replacement: ""}},
+			in:   map[string]string{"tags": ",prod,"},
+			out:  map[string]string{},
+		},
+	}
+
+	for i, test := range cases {
+		for _, rc := range test.cfgs {
+			if err := rc.init(); err != nil {
+				t.Fatalf("%d: unexpected error: %s", i, err)
+			}
+		}
+		out := relabel(test.in, test.cfgs)
+		if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("%d: expected %v, got %v", i, test.out, out)
+		}
+	}
+}
+
+func TestNamespaceRelabelConfig(t *testing.T) {
+	rc, err := NamespaceRelabelConfig("dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(ConsulOpts{URI: "http://localhost:1"}, WithConfig(&Config{Relabel: []*RelabelConfig{rc}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var histogram bool
+	for _, mf := range mfs {
+		if strings.HasPrefix(mf.GetName(), "consul_") {
+			t.Errorf("expected all metrics in the dc1 namespace, got %s", mf.GetName())
+		}
+		if mf.GetName() == "dc1_exporter_api_request_duration_seconds" {
+			histogram = mf.GetType() == dto.MetricType_HISTOGRAM
+		}
+	}
+	if !histogram {
+		t.Error("expected the API request duration to be relabeled as a histogram")
+	}
+}
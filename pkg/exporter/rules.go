@@ -0,0 +1,79 @@
+package exporter
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// alertingRulesTemplate holds curated alerting rules for the metrics of the
+// exporter. Checks are selected by their status label rather than their
+// value, which status_values may change. The template uses [[ ]] delimiters
+// to leave the {{ }} of the alert templates alone.
+var alertingRulesTemplate = template.Must(template.New("rules").Delims("[[", "]]").Parse(`groups:
+  - name: consul
+    rules:
+      - alert: ConsulDown
+        expr: '[[.]]_up == 0'
+        for: 5m
+        labels:
+          severity: critical
+        annotations:
+          summary: Consul is unreachable
+          description: 'The exporter {{ $labels.instance }} can''t query Consul.'
+      - alert: ConsulNoLeader
+        expr: '[[.]]_raft_leader == 0'
+        for: 1m
+        labels:
+          severity: critical
+        annotations:
+          summary: Consul has no Raft leader
+          description: 'The Consul cluster queried by {{ $labels.instance }} has no Raft leader, writes are failing.'
+      - alert: ConsulQuorumAtRisk
+        expr: '[[.]]_raft_peers < max_over_time([[.]]_raft_peers[1d])'
+        for: 5m
+        labels:
+          severity: warning
+        annotations:
+          summary: Consul lost Raft peers
+          description: 'The Consul cluster queried by {{ $labels.instance }} has {{ $value }} Raft peers, fewer than during the last day. Further server failures may lose quorum.'
+      - alert: ConsulNodeFailed
+        expr: '[[.]]_health_node_status{check_name="Serf Health Status",status="critical"}'
+        for: 1m
+        labels:
+          severity: critical
+        annotations:
+          summary: Consul node failed
+          description: 'Node {{ $labels.node }} in datacenter {{ $labels.datacenter }} failed its Serf health check.'
+      - alert: ConsulServiceCritical
+        expr: '[[.]]_health_service_status{status="critical"}'
+        for: 5m
+        labels:
+          severity: warning
+        annotations:
+          summary: Consul service check critical
+          description: 'Check {{ $labels.check }} of service {{ $labels.service_name }} on node {{ $labels.node }} in datacenter {{ $labels.datacenter }} is critical.'
+`))
+
+// AlertingRules returns Prometheus alerting rules in YAML for the metrics of
+// the exporter, whose names start with namespace, Namespace if empty.
+func AlertingRules(namespace string) []byte {
+	if namespace == "" {
+		namespace = Namespace
+	}
+	var buf bytes.Buffer
+	if err := alertingRulesTemplate.Execute(&buf, strings.TrimSuffix(namespace, "_")); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// rulesHandler serves the alerting rules for the namespace.
+func rulesHandler(namespace string) http.Handler {
+	rules := AlertingRules(namespace)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(rules)
+	})
+}
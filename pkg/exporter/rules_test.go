@@ -0,0 +1,39 @@
+package exporter
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAlertingRules(t *testing.T) {
+	e, err := New(ConsulOpts{URI: "localhost:8500"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		e.Describe(descs)
+		close(descs)
+	}()
+	exported := map[string]bool{}
+	for desc := range descs {
+		descMetasMtx.Lock()
+		exported[descMetas[desc].name] = true
+		descMetasMtx.Unlock()
+	}
+
+	rules := string(AlertingRules(""))
+	for _, name := range regexp.MustCompile(`consul_[a-z_]+`).FindAllString(rules, -1) {
+		if !exported[name] {
+			t.Errorf("rules use unknown metric %s", name)
+		}
+	}
+
+	rules = string(AlertingRules("consul_dc1_"))
+	if !strings.Contains(rules, "consul_dc1_raft_leader == 0") {
+		t.Errorf("expected rules with renamed metrics, got %s", rules)
+	}
+}
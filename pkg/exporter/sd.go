@@ -0,0 +1,118 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// sdTargetGroup is a target group of Prometheus' HTTP service discovery.
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// serveSDTargets serves the service instances of the catalog in the format of
+// Prometheus' HTTP service discovery, with the same meta labels as
+// consul_sd_config. The dc and service query parameters restrict the
+// datacenters and services.
+func (e *Exporter) serveSDTargets(w http.ResponseWriter, r *http.Request) {
+	datacenters := r.URL.Query()["dc"]
+	if len(datacenters) == 0 {
+		var err error
+		if datacenters, err = e.client.Catalog().Datacenters(); err != nil {
+			http.Error(w, "Can't query consul: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	groups := []*sdTargetGroup{}
+	for _, dc := range datacenters {
+		dcGroups, err := e.sdTargetGroups(r.Context(), dc, r.URL.Query()["service"])
+		if err != nil {
+			http.Error(w, "Can't query consul: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		groups = append(groups, dcGroups...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		logger.Error("Error encoding targets", "err", err)
+	}
+}
+
+// sdTargetGroups returns a target group per service instance of a
+// datacenter, sorted by service and ID.
+func (e *Exporter) sdTargetGroups(ctx context.Context, dc string, services []string) ([]*sdTargetGroup, error) {
+	queryOptions, cancel := e.queryOptions(ctx, dc, EndpointCatalog)
+	defer cancel()
+
+	if len(services) == 0 {
+		serviceNames, _, err := e.client.Catalog().Services(withFilter(queryOptions, e.servicesFilter))
+		if err != nil {
+			return nil, err
+		}
+		for name := range serviceNames {
+			services = append(services, name)
+		}
+	}
+	sort.Strings(services)
+
+	var (
+		mtx       sync.Mutex
+		instances = make([][]*consul_api.CatalogService, len(services))
+		firstErr  error
+	)
+	e.pool.each(len(services), func(i int) {
+		nodes, _, err := e.client.Catalog().Service(services[i], "", queryOptions)
+		mtx.Lock()
+		defer mtx.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		instances[i] = nodes
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	var groups []*sdTargetGroup
+	for _, nodes := range instances {
+		for _, node := range nodes {
+			groups = append(groups, sdTargetGroupOf(dc, node))
+		}
+	}
+	return groups, nil
+}
+
+// sdTargetGroupOf returns the target group of a service instance.
+func sdTargetGroupOf(dc string, s *consul_api.CatalogService) *sdTargetGroup {
+	address := s.ServiceAddress
+	if address == "" {
+		address = s.Address
+	}
+	labels := map[string]string{
+		"__meta_consul_address":         s.Address,
+		"__meta_consul_dc":              dc,
+		"__meta_consul_node":            s.Node,
+		"__meta_consul_service":         s.ServiceName,
+		"__meta_consul_service_id":      s.ServiceID,
+		"__meta_consul_service_address": s.ServiceAddress,
+		"__meta_consul_service_port":    strconv.Itoa(s.ServicePort),
+		"__meta_consul_tags":            tagsLabel(s.ServiceTags),
+	}
+	for key, value := range s.NodeMeta {
+		labels["__meta_consul_node_meta_"+invalidLabelCharRE.ReplaceAllString(key, "_")] = value
+	}
+	return &sdTargetGroup{
+		Targets: []string{net.JoinHostPort(address, strconv.Itoa(s.ServicePort))},
+		Labels:  labels,
+	}
+}
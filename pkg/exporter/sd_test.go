@@ -0,0 +1,38 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+func TestSDTargetGroupOf(t *testing.T) {
+	group := sdTargetGroupOf("dc1", &consul_api.CatalogService{
+		Node:        "node1",
+		Address:     "fe80::1",
+		NodeMeta:    map[string]string{"rack-id": "r1"},
+		ServiceID:   "web-1",
+		ServiceName: "web",
+		ServiceTags: []string{"prod"},
+		ServicePort: 8080,
+	})
+
+	expected := &sdTargetGroup{
+		Targets: []string{"[fe80::1]:8080"},
+		Labels: map[string]string{
+			"__meta_consul_address":           "fe80::1",
+			"__meta_consul_dc":                "dc1",
+			"__meta_consul_node":              "node1",
+			"__meta_consul_service":           "web",
+			"__meta_consul_service_id":        "web-1",
+			"__meta_consul_service_address":   "",
+			"__meta_consul_service_port":      "8080",
+			"__meta_consul_tags":              ",prod,",
+			"__meta_consul_node_meta_rack_id": "r1",
+		},
+	}
+	if !reflect.DeepEqual(group, expected) {
+		t.Errorf("expected %v, got %v", expected, group)
+	}
+}
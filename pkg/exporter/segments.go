@@ -0,0 +1,52 @@
+package exporter
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// memberStatuses are the names of the Serf member statuses.
+var memberStatuses = map[int]string{
+	0: "none",
+	1: "alive",
+	2: "leaving",
+	3: "left",
+	4: "failed",
+}
+
+// segmentKey groups LAN members by network segment and status.
+type segmentKey struct {
+	segment, status string
+}
+
+// collectSegments collects the LAN members known to the agent by network
+// segment and status. Servers know the members of all segments, clients only
+// those of their own. Members of the default segment have an empty segment.
+func (e *Exporter) collectSegments(ctx context.Context, ch chan<- prometheus.Metric) {
+	var members []*consul_api.AgentMember
+	if err := e.rawQuery(ctx, "/v1/agent/members", &members); err != nil {
+		e.queryError("/v1/agent/members", "", err)
+		return
+	}
+	for key, n := range countSegments(members) {
+		ch <- prometheus.MustNewConstMetric(
+			segmentMembers, prometheus.GaugeValue, float64(n), key.segment, key.status,
+		)
+	}
+}
+
+// countSegments counts members by segment and status.
+func countSegments(members []*consul_api.AgentMember) map[segmentKey]int {
+	counts := map[segmentKey]int{}
+	for _, m := range members {
+		status, ok := memberStatuses[m.Status]
+		if !ok {
+			status = "unknown"
+		}
+		counts[segmentKey{m.Tags["segment"], status}]++
+	}
+	return counts
+}
@@ -0,0 +1,25 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+func TestCountSegments(t *testing.T) {
+	members := []*consul_api.AgentMember{
+		{Name: "server-1", Status: 1, Tags: map[string]string{"role": "consul"}},
+		{Name: "client-1", Status: 1, Tags: map[string]string{"segment": "alpha"}},
+		{Name: "client-2", Status: 4, Tags: map[string]string{"segment": "alpha"}},
+		{Name: "client-3", Status: 1, Tags: map[string]string{"segment": "alpha"}},
+	}
+	expected := map[segmentKey]int{
+		{"", "alive"}:       1,
+		{"alpha", "alive"}:  2,
+		{"alpha", "failed"}: 1,
+	}
+	if got := countSegments(members); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
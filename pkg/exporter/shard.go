@@ -0,0 +1,49 @@
+package exporter
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// shard selects the services and nodes collected by one of several exporter
+// replicas by the hash of their name, so that the replicas split the catalog
+// between them. The zero value selects everything.
+type shard struct {
+	index, total int
+}
+
+func newShard(index, total int) (shard, error) {
+	if total < 0 || index < 0 || (total > 0 && index >= total) || (total == 0 && index > 0) {
+		return shard{}, fmt.Errorf("invalid shard %d of %d", index, total)
+	}
+	return shard{index: index, total: total}, nil
+}
+
+// enabled reports whether the catalog is split between several replicas.
+func (s shard) enabled() bool {
+	return s.total > 1
+}
+
+// owns reports whether the service or node name is collected by this shard.
+func (s shard) owns(name string) bool {
+	if !s.enabled() {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32()%uint32(s.total)) == s.index
+}
+
+// services returns the services of the catalog owned by this shard.
+func (s shard) services(serviceNames map[string][]string) map[string][]string {
+	if !s.enabled() {
+		return serviceNames
+	}
+	owned := make(map[string][]string, len(serviceNames)/s.total+1)
+	for name, tags := range serviceNames {
+		if s.owns(name) {
+			owned[name] = tags
+		}
+	}
+	return owned
+}
@@ -0,0 +1,36 @@
+package exporter
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShard(t *testing.T) {
+	if _, err := newShard(3, 3); err == nil {
+		t.Errorf("expected error for shard index out of range")
+	}
+
+	services := map[string][]string{}
+	for i := 0; i < 100; i++ {
+		services[fmt.Sprintf("service-%d", i)] = nil
+	}
+	collected := map[string]int{}
+	for i := 0; i < 3; i++ {
+		s, err := newShard(i, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		owned := s.services(services)
+		if len(owned) == 0 {
+			t.Errorf("expected shard %d to own some services", i)
+		}
+		for name := range owned {
+			collected[name]++
+		}
+	}
+	for name := range services {
+		if collected[name] != 1 {
+			t.Errorf("expected %s to be collected by exactly one shard, got %d", name, collected[name])
+		}
+	}
+}
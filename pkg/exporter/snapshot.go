@@ -0,0 +1,125 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+// snapshot is the cluster state seen by a full collection, served as JSON so
+// that other tooling can reuse it instead of querying Consul again. Its
+// methods are no-ops on a nil snapshot, so that collectors don't need to
+// check whether they are recording.
+type snapshot struct {
+	Time        time.Time                      `json:"time"`
+	Peers       []string                       `json:"peers"`
+	Leader      string                         `json:"leader"`
+	Datacenters map[string]*datacenterSnapshot `json:"datacenters"`
+	KV          map[string]string              `json:"kv"`
+
+	mtx sync.Mutex
+}
+
+type datacenterSnapshot struct {
+	Nodes    []*consul_api.Node        `json:"nodes"`
+	Services map[string][]string       `json:"services"`
+	Checks   []*consul_api.HealthCheck `json:"checks"`
+}
+
+func newSnapshot(peers []string) *snapshot {
+	return &snapshot{
+		Time:        time.Now(),
+		Peers:       peers,
+		Datacenters: map[string]*datacenterSnapshot{},
+		KV:          map[string]string{},
+	}
+}
+
+// datacenter returns the state of dc, s.mtx must be held.
+func (s *snapshot) datacenter(dc string) *datacenterSnapshot {
+	dcs, ok := s.Datacenters[dc]
+	if !ok {
+		dcs = &datacenterSnapshot{Services: map[string][]string{}}
+		s.Datacenters[dc] = dcs
+	}
+	return dcs
+}
+
+func (s *snapshot) setLeader(leader string) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.Leader = leader
+}
+
+func (s *snapshot) setNodes(dc string, nodes []*consul_api.Node) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.datacenter(dc).Nodes = nodes
+}
+
+func (s *snapshot) setServices(dc string, services map[string][]string) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.datacenter(dc).Services = services
+}
+
+func (s *snapshot) addCheck(dc string, hc *consul_api.HealthCheck) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	dcs := s.datacenter(dc)
+	dcs.Checks = append(dcs.Checks, hc)
+}
+
+func (s *snapshot) setKV(key string, value []byte) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.KV[key] = string(value)
+}
+
+// snapshotStore holds the snapshot of the last full collection.
+type snapshotStore struct {
+	mtx  sync.RWMutex
+	last *snapshot
+}
+
+func (st *snapshotStore) store(s *snapshot) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	st.last = s
+}
+
+// ServeHTTP serves the last snapshot as JSON.
+func (st *snapshotStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st.mtx.RLock()
+	last := st.last
+	st.mtx.RUnlock()
+	if last == nil {
+		http.Error(w, "No successful collection yet.", http.StatusServiceUnavailable)
+		return
+	}
+
+	last.mtx.Lock()
+	defer last.mtx.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(last); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
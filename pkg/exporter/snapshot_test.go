@@ -0,0 +1,48 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+func TestSnapshotStore(t *testing.T) {
+	st := &snapshotStore{}
+	rec := httptest.NewRecorder()
+	st.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/snapshot", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before the first collection, got %d", rec.Code)
+	}
+
+	s := newSnapshot([]string{"10.0.0.1:8300"})
+	s.setLeader("10.0.0.1:8300")
+	s.setNodes("dc1", []*consul_api.Node{{Node: "node1"}})
+	s.addCheck("dc1", &consul_api.HealthCheck{CheckID: "serfHealth", Status: "passing"})
+	s.setKV("config/replicas", []byte("3"))
+	st.store(s)
+
+	rec = httptest.NewRecorder()
+	st.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/snapshot", nil))
+	var got struct {
+		Leader      string
+		Datacenters map[string]struct {
+			Nodes  []struct{ Node string }
+			Checks []struct{ CheckID string }
+		}
+		KV map[string]string
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	dc := got.Datacenters["dc1"]
+	if got.Leader != "10.0.0.1:8300" || len(dc.Nodes) != 1 || len(dc.Checks) != 1 || got.KV["config/replicas"] != "3" {
+		t.Errorf("unexpected snapshot %s", rec.Body)
+	}
+
+	// Collectors call the recording methods unconditionally.
+	var none *snapshot
+	none.setLeader("10.0.0.1:8300")
+}
@@ -0,0 +1,106 @@
+package exporter
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// datacenterStatus is the outcome of a collection of a datacenter.
+type datacenterStatus struct {
+	Datacenter string
+	Start      time.Time
+	Duration   time.Duration
+	// Error is the first failed query of the collection, if any.
+	Error    string
+	Services int
+	Checks   int
+	// Index is the highest Raft index returned by the queries of the
+	// datacenter.
+	Index uint64
+}
+
+// startDatacenter records the start of the collection of a datacenter.
+func (s *exporterStats) startDatacenter(dc string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.running[dc] = &datacenterStatus{Datacenter: dc, Start: time.Now()}
+}
+
+// failed records the first failed query of the running collection of a
+// datacenter.
+func (s *exporterStats) failed(dc, endpoint string, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if st, ok := s.running[dc]; ok && st.Error == "" {
+		st.Error = endpoint + ": " + err.Error()
+	}
+}
+
+// setServices records the number of services collected from a datacenter.
+func (s *exporterStats) setServices(dc string, services int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if st, ok := s.running[dc]; ok {
+		st.Services = services
+	}
+}
+
+// addChecks adds to the number of health checks collected from a
+// datacenter.
+func (s *exporterStats) addChecks(dc string, checks int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if st, ok := s.running[dc]; ok {
+		st.Checks += checks
+	}
+}
+
+// finishDatacenter records the end of the collection of a datacenter.
+func (s *exporterStats) finishDatacenter(dc string, index uint64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	st, ok := s.running[dc]
+	if !ok {
+		return
+	}
+	delete(s.running, dc)
+	st.Duration = time.Since(st.Start)
+	st.Index = index
+	s.last[dc] = st
+}
+
+// datacenterStatuses returns the last collection of each datacenter, sorted
+// by datacenter.
+func (s *exporterStats) datacenterStatuses() []datacenterStatus {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	statuses := make([]datacenterStatus, 0, len(s.last))
+	for _, st := range s.last {
+		statuses = append(statuses, *st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Datacenter < statuses[j].Datacenter })
+	return statuses
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<html>
+<head><title>Consul Exporter Status</title></head>
+<body>
+<h1>Consul Exporter Status</h1>
+<h2>Datacenters</h2>
+{{if .}}<table border="1" cellpadding="4">
+<tr><th>Datacenter</th><th>Last scrape</th><th>Duration</th><th>Services</th><th>Checks</th><th>Raft index</th><th>Error</th></tr>
+{{range .}}<tr><td>{{.Datacenter}}</td><td>{{.Start.Format "2006-01-02T15:04:05Z07:00"}}</td><td>{{.Duration}}</td><td>{{.Services}}</td><td>{{.Checks}}</td><td>{{.Index}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>{{else}}<p>No datacenter collected yet.</p>{{end}}
+</body>
+</html>
+`))
+
+// serveStatus serves the details of the last collection of each datacenter.
+func (e *Exporter) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, e.stats.datacenterStatuses()); err != nil {
+		logger.Error("Error rendering status page", "err", err)
+	}
+}
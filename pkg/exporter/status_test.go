@@ -0,0 +1,40 @@
+package exporter
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDatacenterStatuses(t *testing.T) {
+	s := newExporterStats()
+	s.startDatacenter("dc2")
+	s.failed("dc2", "/v1/health/state", errors.New("timeout"))
+	s.failed("dc2", "/v1/health/service", errors.New("ignored"))
+	s.finishDatacenter("dc2", 0)
+	s.startDatacenter("dc1")
+	s.setServices("dc1", 3)
+	s.addChecks("dc1", 7)
+	s.finishDatacenter("dc1", 42)
+	// Failures outside of a collection are ignored.
+	s.failed("dc1", "/v1/catalog/nodes", errors.New("ignored"))
+
+	statuses := s.datacenterStatuses()
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 statuses, got %d", len(statuses))
+	}
+	if dc1 := statuses[0]; dc1.Datacenter != "dc1" || dc1.Services != 3 || dc1.Checks != 7 || dc1.Index != 42 || dc1.Error != "" {
+		t.Errorf("unexpected status of dc1: %+v", dc1)
+	}
+	if dc2 := statuses[1]; dc2.Datacenter != "dc2" || dc2.Error != "/v1/health/state: timeout" {
+		t.Errorf("unexpected status of dc2: %+v", dc2)
+	}
+
+	e := &Exporter{stats: s}
+	rec := httptest.NewRecorder()
+	e.serveStatus(rec, httptest.NewRequest("GET", "/status", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "<td>dc1</td>") || !strings.Contains(body, "/v1/health/state: timeout") {
+		t.Errorf("unexpected status page:\n%s", body)
+	}
+}
@@ -0,0 +1,137 @@
+package exporter
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectSuccess holds the time of the last successful collection, overall
+// and per collector. It is shared by the filtered copies of an exporter.
+type collectSuccess struct {
+	mtx        sync.Mutex
+	last       time.Time
+	collectors map[string]time.Time
+}
+
+func newCollectSuccess() *collectSuccess {
+	return &collectSuccess{collectors: map[string]time.Time{}}
+}
+
+// update records a successful run at now of the collectors which didn't
+// fail. The overall time is only updated if full is true and nothing failed.
+func (s *collectSuccess) update(now time.Time, collectors []string, f *collectFailures, full bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, c := range collectors {
+		if !f.failed(c) {
+			s.collectors[c] = now
+		}
+	}
+	if full && !f.any() {
+		s.last = now
+	}
+}
+
+// collect exports the recorded times, the overall time with an empty
+// collector label.
+func (s *collectSuccess) collect(ch chan<- prometheus.Metric) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.last.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			lastCollectSuccess, prometheus.GaugeValue, float64(s.last.UnixNano())/1e9, "",
+		)
+	}
+	for c, t := range s.collectors {
+		ch <- prometheus.MustNewConstMetric(
+			lastCollectSuccess, prometheus.GaugeValue, float64(t.UnixNano())/1e9, c,
+		)
+	}
+}
+
+// collectResults exports whether each of the collectors ran without failed
+// queries during the collection.
+func collectResults(ch chan<- prometheus.Metric, collectors []string, f *collectFailures) {
+	for _, c := range collectors {
+		v := 1.0
+		if f.failed(c) {
+			v = 0
+		}
+		ch <- prometheus.MustNewConstMetric(collectorSuccess, prometheus.GaugeValue, v, c)
+	}
+}
+
+// collectFailures records the collectors with failed queries during a
+// single collection. Its methods are safe to call on a nil value.
+type collectFailures struct {
+	mtx        sync.Mutex
+	collectors map[string]bool
+}
+
+func newCollectFailures() *collectFailures {
+	return &collectFailures{collectors: map[string]bool{}}
+}
+
+// add marks the collectors relying on the endpoint as failed.
+func (f *collectFailures) add(endpoint string) {
+	if f == nil {
+		return
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for _, c := range endpointCollectors(endpoint) {
+		f.collectors[c] = true
+	}
+}
+
+// addCollector marks the collector as failed.
+func (f *collectFailures) addCollector(collector string) {
+	if f == nil {
+		return
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.collectors[collector] = true
+}
+
+func (f *collectFailures) failed(collector string) bool {
+	if f == nil {
+		return false
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.collectors[collector]
+}
+
+func (f *collectFailures) any() bool {
+	if f == nil {
+		return false
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return len(f.collectors) > 0
+}
+
+// endpointCollectors returns the collectors whose output depends on the
+// endpoint. The services of the catalog are also needed by the health
+// collector.
+func endpointCollectors(endpoint string) []string {
+	switch {
+	case endpoint == "/v1/status/peers" || endpoint == "/v1/status/leader":
+		return []string{collectorRaft}
+	case endpoint == "/v1/catalog/nodes":
+		return []string{collectorCatalog}
+	case strings.HasPrefix(endpoint, "/v1/catalog/"):
+		return []string{collectorCatalog, collectorHealth}
+	case strings.HasPrefix(endpoint, "/v1/health/"):
+		return []string{collectorHealth}
+	case endpoint == "/v1/kv" || endpoint == "/v1/txn":
+		return []string{collectorKV}
+	case strings.HasPrefix(endpoint, "/v1/agent/"):
+		return []string{collectorAgent}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package exporter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollectSuccess(t *testing.T) {
+	s := newCollectSuccess()
+	first := time.Unix(100, 0)
+	s.update(first, []string{collectorRaft, collectorKV}, nil, true)
+
+	f := newCollectFailures()
+	f.add("/v1/kv")
+	s.update(time.Unix(200, 0), []string{collectorRaft, collectorKV}, f, true)
+
+	ch := make(chan prometheus.Metric, 10)
+	s.collect(ch)
+	close(ch)
+
+	got := map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		got[pb.Label[0].GetValue()] = pb.Gauge.GetValue()
+	}
+	want := map[string]float64{"": 100, collectorRaft: 200, collectorKV: 100}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for c, v := range want {
+		if got[c] != v {
+			t.Errorf("collector %q: want %v, got %v", c, v, got[c])
+		}
+	}
+}
+
+func TestEndpointCollectors(t *testing.T) {
+	for endpoint, want := range map[string][]string{
+		"/v1/catalog/services": {collectorCatalog, collectorHealth},
+		"/v1/catalog/nodes":    {collectorCatalog},
+		"/v1/health/service":   {collectorHealth},
+		"/v1/txn":              {collectorKV},
+		"/v1/agent/self":       {collectorAgent},
+		"/v1/agent/checks":     {collectorAgent},
+		"/v1/acl/token":        nil,
+	} {
+		got := endpointCollectors(endpoint)
+		if len(got) != len(want) {
+			t.Errorf("%s: want %v, got %v", endpoint, want, got)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: want %v, got %v", endpoint, want, got)
+			}
+		}
+	}
+}
+
+func TestCollectResults(t *testing.T) {
+	f := newCollectFailures()
+	f.add("/v1/status/peers")
+
+	ch := make(chan prometheus.Metric, 10)
+	collectResults(ch, []string{collectorRaft, collectorKV}, f)
+	close(ch)
+
+	got := map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		got[pb.Label[0].GetValue()] = pb.Gauge.GetValue()
+	}
+	if got[collectorRaft] != 0 || got[collectorKV] != 1 {
+		t.Errorf("a failed peers query should only fail the raft collector, got %v", got)
+	}
+}
+
+func TestCollectorError(t *testing.T) {
+	e := &Exporter{failures: newCollectFailures(), stats: newExporterStats()}
+	e.collectorError(collectorProbe, "/v1/health/service", "", errors.New("unreachable"))
+	if !e.failures.failed(collectorProbe) {
+		t.Error("expected the probe collector to fail")
+	}
+	if e.failures.failed(collectorHealth) {
+		t.Error("the health collector shouldn't fail for the probe's queries")
+	}
+}
@@ -0,0 +1,68 @@
+package exporter
+
+import (
+	"sort"
+
+	consul_api "github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serviceTagPair is a service with one of its tags.
+type serviceTagPair struct {
+	service, tag string
+}
+
+// collectTagBreakdown collects the number of all and of passing instances of
+// every service for each of the tags to break down that it carries, e.g. to
+// monitor blue/green deployments. It needs one query per service and tag.
+func (e *Exporter) collectTagBreakdown(ch chan<- prometheus.Metric, serviceNames map[string][]string, queryOptions *consul_api.QueryOptions) {
+	pairs := tagPairs(serviceNames, e.breakdownTags)
+	dc := queryOptions.Datacenter
+	e.pool.each(len(pairs), func(i int) {
+		p := pairs[i]
+		if queryOptions.Context().Err() != nil {
+			return
+		}
+		entries, meta, err := e.client.Health().Service(p.service, p.tag, false, queryOptions)
+		if err != nil {
+			e.queryError("/v1/health/service", dc, err, "service", p.service, "tag", p.tag)
+			return
+		}
+		e.indexes.record("/v1/health/service", dc, meta.LastIndex)
+
+		healthy := 0
+		for _, entry := range entries {
+			if entry.Checks.AggregatedStatus() == consul_api.HealthPassing {
+				healthy++
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(
+			serviceTagInstances, prometheus.GaugeValue, float64(len(entries)), p.service, p.tag, dc,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			serviceTagHealthy, prometheus.GaugeValue, float64(healthy), p.service, p.tag, dc,
+		)
+	})
+}
+
+// tagPairs returns the services with each of their tags to break down, sorted
+// by service and tag.
+func tagPairs(serviceNames map[string][]string, breakdown map[string]bool) []serviceTagPair {
+	var pairs []serviceTagPair
+	for service, tags := range serviceNames {
+		seen := map[string]bool{}
+		for _, tag := range tags {
+			if breakdown[tag] && !seen[tag] {
+				seen[tag] = true
+				pairs = append(pairs, serviceTagPair{service, tag})
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].service != pairs[j].service {
+			return pairs[i].service < pairs[j].service
+		}
+		return pairs[i].tag < pairs[j].tag
+	})
+	return pairs
+}
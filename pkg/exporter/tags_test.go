@@ -0,0 +1,19 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagPairs(t *testing.T) {
+	names := map[string][]string{
+		"web":   {"green", "blue", "http", "blue"},
+		"db":    {"primary"},
+		"cache": {"canary"},
+	}
+	got := tagPairs(names, map[string]bool{"blue": true, "green": true, "canary": true})
+	want := []serviceTagPair{{"cache", "canary"}, {"web", "blue"}, {"web", "green"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
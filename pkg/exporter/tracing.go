@@ -0,0 +1,275 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/version"
+)
+
+const (
+	// tracerQueueSize bounds the number of ended spans waiting for export,
+	// spans are dropped when the receiver can't keep up.
+	tracerQueueSize = 2048
+	tracerBatchSize = 512
+	tracerInterval  = 5 * time.Second
+	tracerTimeout   = 10 * time.Second
+
+	// Span kinds and status codes of the OTLP trace data model.
+	spanKindInternal = 1
+	spanKindClient   = 3
+	statusCodeError  = 2
+)
+
+// tracer records spans of the scrape pipeline and exports them in batches to
+// an OpenTelemetry collector using OTLP over HTTP with JSON encoding. Its
+// methods are no-ops on a nil tracer, so that tracing can be disabled without
+// checks at every call site.
+type tracer struct {
+	url    string
+	client *http.Client
+	queue  chan *span
+}
+
+// newTracer returns a tracer exporting to the OTLP/HTTP endpoint, e.g.
+// http://localhost:4318, and starts its export loop.
+func newTracer(endpoint string) *tracer {
+	t := &tracer{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: tracerTimeout},
+		queue:  make(chan *span, tracerQueueSize),
+	}
+	go t.run()
+	return t
+}
+
+// span is a timed operation of a trace.
+type span struct {
+	tracer   *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []string
+	err      error
+}
+
+type spanContextKey struct{}
+
+// start starts a span that is a child of the span of ctx, if any, and
+// returns a context carrying the new span. attrs are pairs of attribute names
+// and values.
+func (t *tracer) start(ctx context.Context, name string, attrs ...string) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: t, name: name, kind: spanKindInternal, start: time.Now(), attrs: attrs}
+	if parent := spanFromContext(ctx); parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// startCall starts a client span of a call to a Consul API endpoint. It is
+// used directly for calls that don't accept query options and thus no
+// context.
+func (t *tracer) startCall(ctx context.Context, method, endpoint string, attrs ...string) *span {
+	_, s := t.start(ctx, method+" "+endpoint, append([]string{"http.method", method}, attrs...)...)
+	if s != nil {
+		s.kind = spanKindClient
+	}
+	return s
+}
+
+// spanFromContext returns the span of ctx or nil.
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanContextKey{}).(*span)
+	return s
+}
+
+// finish ends the span, marking it as failed if err isn't nil, and queues it
+// for export.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	select {
+	case s.tracer.queue <- s:
+	default:
+	}
+}
+
+// run exports the queued spans every tracerInterval or whenever a batch is
+// full.
+func (t *tracer) run() {
+	ticker := time.NewTicker(tracerInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) < tracerBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := t.export(batch); err != nil {
+			logger.Error("Error exporting spans", "url", t.url, "spans", len(batch), "err", err)
+		}
+		batch = nil
+	}
+}
+
+func (t *tracer) export(spans []*span) error {
+	data, err := json.Marshal(newOTLPTraces(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "consul_exporter/"+version.Version)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// The following types mirror the JSON encoding of OTLP's
+// ExportTraceServiceRequest, the OpenTelemetry SDK isn't vendored.
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// newOTLPTraces converts spans to an export request.
+func newOTLPTraces(spans []*span) *otlpTraces {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "consul_exporter", Version: version.Version}}
+	for _, s := range spans {
+		out := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attrs...),
+		}
+		if s.parentID != [8]byte{} {
+			out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			out.Status = &otlpStatus{Code: statusCodeError, Message: s.err.Error()}
+		}
+		scope.Spans = append(scope.Spans, out)
+	}
+	return &otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes("service.name", "consul_exporter")},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+}
+
+func otlpAttributes(pairs ...string) []otlpAttribute {
+	var attrs []otlpAttribute
+	for i := 0; i+1 < len(pairs); i += 2 {
+		attrs = append(attrs, otlpAttribute{Key: pairs[i], Value: otlpValue{StringValue: pairs[i+1]}})
+	}
+	return attrs
+}
+
+// tracedRequest starts a client span for a request to the Consul API if its
+// context carries a span. The returned function ends it.
+func tracedRequest(req *http.Request, endpoint string) func(*http.Response, error) {
+	parent := spanFromContext(req.Context())
+	if parent == nil {
+		return func(*http.Response, error) {}
+	}
+	s := parent.tracer.startCall(req.Context(), req.Method, endpoint,
+		"http.url", req.URL.Path,
+		"consul.datacenter", req.URL.Query().Get("dc"),
+	)
+	return func(resp *http.Response, err error) {
+		if err == nil {
+			s.attrs = append(s.attrs, "http.status_code", strconv.Itoa(resp.StatusCode))
+			if resp.StatusCode >= 400 {
+				err = fmt.Errorf("%s", resp.Status)
+			}
+		}
+		s.finish(err)
+	}
+}
@@ -0,0 +1,38 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	var disabled *tracer
+	ctx, s := disabled.start(context.Background(), "collect")
+	if s != nil || spanFromContext(ctx) != nil {
+		t.Fatal("disabled tracer started a span")
+	}
+	s.finish(nil)
+
+	tr := &tracer{queue: make(chan *span, 2)}
+	ctx, root := tr.start(context.Background(), "collect")
+	call := tr.startCall(ctx, "GET", "/v1/status/leader")
+	call.finish(errors.New("timeout"))
+	root.finish(nil)
+
+	traces := newOTLPTraces([]*span{<-tr.queue, <-tr.queue})
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	child, parent := spans[0], spans[1]
+	if child.TraceID != parent.TraceID || child.ParentSpanID != parent.SpanID || parent.ParentSpanID != "" {
+		t.Errorf("unexpected span hierarchy: %+v, %+v", child, parent)
+	}
+	if child.Name != "GET /v1/status/leader" || child.Kind != spanKindClient {
+		t.Errorf("unexpected client span %+v", child)
+	}
+	if child.Status == nil || child.Status.Code != statusCodeError || parent.Status != nil {
+		t.Errorf("unexpected span status %+v, %+v", child.Status, parent.Status)
+	}
+}
@@ -0,0 +1,55 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector(collectorWAN, wanCollector{}, false)
+}
+
+// wanCollector probes every remote datacenter with a stale catalog query,
+// which any server of the datacenter answers, even without a leader. A failed
+// probe thus points at the WAN link rather than at an outage of the remote
+// datacenter's Raft.
+type wanCollector struct{}
+
+func (wanCollector) collect(s *scrape, ch chan<- prometheus.Metric) {
+	e := s.e
+	defer e.observe(ch, collectorWAN, "", time.Now())
+
+	var self map[string]map[string]interface{}
+	if err := e.rawQuery(s.ctx, "/v1/agent/self", &self); err != nil {
+		e.collectorError(collectorWAN, "/v1/agent/self", "", err)
+		return
+	}
+	local := selfValue(self["Config"]["Datacenter"])
+
+	s.forEachDatacenter(func(dc string) {
+		if dc == local {
+			return
+		}
+		ctx, span := e.tracer.start(s.ctx, "probe datacenter", "consul.datacenter", dc)
+		defer span.finish(nil)
+
+		opts, cancel := e.queryOptions(ctx, dc, EndpointCatalog)
+		defer cancel()
+		opts.AllowStale, opts.RequireConsistent = true, false
+		start := time.Now()
+		_, _, err := e.client.Catalog().Services(opts)
+		latency := time.Since(start).Seconds()
+		if s.ctx.Err() != nil {
+			// The scrape was given up, the datacenter is not to blame.
+			return
+		}
+		if err != nil {
+			logger.Warn("Remote datacenter unreachable", "datacenter", dc, "err", err)
+			ch <- prometheus.MustNewConstMetric(wanReachable, prometheus.GaugeValue, 0, dc)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(wanReachable, prometheus.GaugeValue, 1, dc)
+		ch <- prometheus.MustNewConstMetric(wanLatency, prometheus.GaugeValue, latency, dc)
+	})
+}
@@ -0,0 +1,56 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWANCollector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/agent/self":
+			w.Write([]byte(`{"Config": {"Datacenter": "dc1"}}`))
+		case "/v1/catalog/datacenters":
+			w.Write([]byte(`["dc1", "dc2", "dc3"]`))
+		case "/v1/catalog/services":
+			if r.URL.Query().Get("dc") == "dc3" {
+				http.Error(w, "No path to datacenter", http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(`{"consul": []}`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	e, err := New(ConsulOpts{URI: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCollectors([]string{collectorWAN}); err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reachable := map[string]float64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "consul_wan_datacenter_reachable" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			reachable[m.Label[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	if len(reachable) != 2 || reachable["dc2"] != 1 || reachable["dc3"] != 0 {
+		t.Errorf("expected dc2 reachable and dc3 unreachable, got %v", reachable)
+	}
+}
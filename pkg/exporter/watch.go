@@ -0,0 +1,111 @@
+package exporter
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	consul_api "github.com/hashicorp/consul/api"
+)
+
+const (
+	// watchWaitTime bounds the duration of a single blocking query.
+	watchWaitTime = 5 * time.Minute
+	// watchRetryInterval is the delay before retrying a failed query.
+	watchRetryInterval = 5 * time.Second
+)
+
+var errWatchNotSynced = errors.New("watch hasn't synced yet")
+
+// watchQuery runs a blocking query with the given options.
+type watchQuery func(opts *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error)
+
+// watcher keeps the result of a query up to date with blocking queries, so
+// that scrapes don't need to run the query themselves.
+type watcher struct {
+	endpoint string
+	dc       string
+	query    watchQuery
+	opts     consul_api.QueryOptions
+	// logArgs are added to the log messages of failed queries.
+	logArgs []interface{}
+
+	mtx    sync.RWMutex
+	value  interface{}
+	index  uint64
+	err    error
+	synced bool
+}
+
+func newWatcher(endpoint string, opts consul_api.QueryOptions, query watchQuery, logArgs ...interface{}) *watcher {
+	return &watcher{
+		endpoint: endpoint,
+		dc:       opts.Datacenter,
+		query:    query,
+		opts:     opts,
+		logArgs:  logArgs,
+		err:      errWatchNotSynced,
+	}
+}
+
+// run watches the query until the process exits or the context of its query
+// options is canceled.
+func (w *watcher) run() {
+	var index uint64
+	for {
+		opts := w.opts
+		opts.WaitIndex = index
+		opts.WaitTime = watchWaitTime
+
+		value, meta, err := w.query(&opts)
+		if w.opts.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Error("Error watching query", append([]interface{}{"endpoint", w.endpoint, "datacenter", w.dc, "err", err}, w.logArgs...)...)
+			w.mtx.Lock()
+			w.err = err
+			w.mtx.Unlock()
+			apiRetries.WithLabelValues(w.endpoint).Inc()
+			time.Sleep(watchRetryInterval)
+			continue
+		}
+
+		// The index going backwards means it was reset, e.g. by a snapshot
+		// restore, and the watch has to start over.
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+
+		w.mtx.Lock()
+		w.value, w.index, w.err, w.synced = value, index, nil, true
+		w.mtx.Unlock()
+	}
+}
+
+// get returns the cached result. Once synced, the last known result is
+// returned even if the watch is currently failing.
+func (w *watcher) get() (interface{}, error) {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	if !w.synced {
+		return nil, w.err
+	}
+	return w.value, nil
+}
+
+// lastIndex returns the index of the cached result.
+func (w *watcher) lastIndex() uint64 {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	return w.index
+}
+
+// newKVWatcher returns a watcher of the pairs under a prefix.
+func newKVWatcher(client *consul_api.Client, prefix string, opts consul_api.QueryOptions) *watcher {
+	return newWatcher("/v1/kv", opts, func(opts *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+		return client.KV().List(prefix, opts)
+	}, "prefix", prefix)
+}
@@ -0,0 +1,194 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	consul_api "github.com/hashicorp/consul/api"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestWatcher(t *testing.T) {
+	block := make(chan struct{})
+	calls := 0
+	w := newWatcher("/v1/catalog/services", consul_api.QueryOptions{Datacenter: "dc1"}, func(opts *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+		calls++
+		if calls > 1 {
+			if opts.WaitIndex != 42 {
+				t.Errorf("expected blocking query on index 42, got %d", opts.WaitIndex)
+			}
+			<-block
+		}
+		return map[string][]string{"web": nil}, &consul_api.QueryMeta{LastIndex: 42}, nil
+	})
+
+	if _, err := w.get(); err != errWatchNotSynced {
+		t.Errorf("expected not synced error before the first result, got %v", err)
+	}
+	go w.run()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		v, err := w.get()
+		if err == nil {
+			if _, ok := v.(map[string][]string)["web"]; !ok {
+				t.Errorf("unexpected watched value %v", v)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watch didn't sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.lastIndex() != 42 {
+		t.Errorf("expected index 42, got %d", w.lastIndex())
+	}
+}
+
+func TestWatcherRetries(t *testing.T) {
+	retries := func() float64 {
+		var pb dto.Metric
+		if err := apiRetries.WithLabelValues("/v1/test/retries").Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		return pb.GetCounter().GetValue()
+	}
+	before := retries()
+	w := newWatcher("/v1/test/retries", consul_api.QueryOptions{Datacenter: "dc1"}, func(opts *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+		return nil, nil, errors.New("connection refused")
+	})
+	go w.run()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for retries() != before+1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a retry to be counted, got %v", retries()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServiceHealthWatches(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/web" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Query().Get("index") != "" {
+			// Blocking queries time out without changes.
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		w.Header().Set("X-Consul-Index", "7")
+		w.Write([]byte(`[{"Node": {"Node": "n1"}, "Service": {"ID": "web-1", "Service": "web"}, "Checks": [
+			{"Node": "n1", "CheckID": "serfHealth", "Status": "passing"},
+			{"Node": "n1", "CheckID": "service:web-1", "ServiceID": "web-1", "ServiceName": "web", "Status": "critical"}
+		]}]`))
+	}))
+	defer server.Close()
+	client, err := consul_api.NewClient(&consul_api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sw := newServiceHealthWatches(client.Health(), consul_api.QueryOptions{Datacenter: "dc1"})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		checks, index, err := sw.checks(map[string][]string{"web": nil})
+		if err == nil {
+			// Node checks are left to the health state watch.
+			if len(checks) != 1 || checks[0].CheckID != "service:web-1" {
+				t.Errorf("expected the service check of web-1, got %v", checks)
+			}
+			if index != 7 {
+				t.Errorf("expected index 7, got %d", index)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watch didn't sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Watches of services which went away are stopped.
+	if checks, _, err := sw.checks(map[string][]string{}); err != nil || len(checks) != 0 {
+		t.Errorf("expected no checks, got %v, %v", checks, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	stopped := atomic.LoadInt32(&requests)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&requests); n != stopped {
+		t.Errorf("expected the watch of the removed service to stop, got %d more queries", n-stopped)
+	}
+}
+
+func TestCatalogWatchesDatacenters(t *testing.T) {
+	var (
+		dcs      atomic.Value
+		requests int32
+	)
+	dcs.Store(`["dc1"]`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/catalog/datacenters" {
+			w.Write([]byte(dcs.Load().(string)))
+			return
+		}
+		if r.URL.Query().Get("dc") == "dc1" {
+			atomic.AddInt32(&requests, 1)
+		}
+		if r.URL.Query().Get("index") != "" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		w.Header().Set("X-Consul-Index", "1")
+		if r.URL.Path == "/v1/catalog/services" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	e, err := New(ConsulOpts{URI: server.URL}, WithCatalogWatch(), WithDatacentersTTL(time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := e.watches.datacenter(ctx, "dc1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.watches.datacenter(ctx, "made-up"); err == nil {
+		t.Error("expected no watches of a datacenter unknown to the catalog")
+	}
+	if len(e.watches.dcs) != 1 {
+		t.Errorf("expected the watches of a single datacenter, got %d", len(e.watches.dcs))
+	}
+
+	// The watches of datacenters which left the catalog are stopped.
+	dcs.Store(`["dc2"]`)
+	if _, err := e.watches.datacenter(ctx, "dc2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.watches.dcs["dc1"]; ok {
+		t.Error("expected the watches of dc1 to be removed")
+	}
+	time.Sleep(100 * time.Millisecond)
+	stopped := atomic.LoadInt32(&requests)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&requests); n != stopped {
+		t.Errorf("expected the watches of dc1 to stop, got %d more queries", n-stopped)
+	}
+}
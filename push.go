@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pushEvery calls push every interval until the process exits.
+func pushEvery(interval time.Duration, target string, push func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := push(); err != nil {
+			logger.Error("Error pushing metrics", "target", target, "err", err)
+		}
+		<-ticker.C
+	}
+}
+
+// gather returns the metrics of g. Gather returns whatever it could collect
+// along with errors, so they are only logged.
+func gather(g prometheus.Gatherer) []*dto.MetricFamily {
+	mfs, err := g.Gather()
+	if err != nil {
+		logger.Error("Error gathering metrics", "err", err)
+	}
+	return mfs
+}
+
+// pushgateway replaces the metrics of a group on a Pushgateway with the
+// metrics of a gatherer.
+type pushgateway struct {
+	url      string
+	client   *http.Client
+	gatherer prometheus.Gatherer
+}
+
+// newPushgateway returns a pusher for the group identified by the job and the
+// grouping labels, given as name=value pairs.
+func newPushgateway(gatewayURL, job string, grouping []string, timeout time.Duration, g prometheus.Gatherer) (*pushgateway, error) {
+	if !strings.Contains(gatewayURL, "://") {
+		gatewayURL = "http://" + gatewayURL
+	}
+	if job == "" {
+		return nil, fmt.Errorf("job name is required")
+	}
+
+	path := []string{strings.TrimSuffix(gatewayURL, "/"), "metrics", "job", url.PathEscape(job)}
+	labels := make(map[string]string, len(grouping))
+	for _, l := range grouping {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || !model.LabelName(parts[0]).IsValid() {
+			return nil, fmt.Errorf("invalid grouping label %q", l)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path = append(path, name, url.PathEscape(labels[name]))
+	}
+
+	return &pushgateway{
+		url:      strings.Join(path, "/"),
+		client:   &http.Client{Timeout: timeout},
+		gatherer: g,
+	}, nil
+}
+
+// push replaces the metrics of the group.
+func (p *pushgateway) push() error {
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.FmtProtoDelim)
+	for _, mf := range gather(p.gatherer) {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest("PUT", p.url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(expfmt.FmtProtoDelim))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// writeTextfile atomically replaces the file at path with the metrics of g in
+// the text format, for node_exporter's textfile collector. It doesn't support
+// timestamps, so they are dropped.
+func writeTextfile(path string, g prometheus.Gatherer) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	for _, mf := range gather(g) {
+		for _, m := range mf.Metric {
+			m.TimestampMs = nil
+		}
+		if _, err := expfmt.MetricFamilyToText(tmp, mf); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// graphite sends the metrics of a gatherer to Graphite using the plaintext
+// protocol, like client_golang's Graphite bridge.
+type graphite struct {
+	address  string
+	prefix   string
+	timeout  time.Duration
+	gatherer prometheus.Gatherer
+}
+
+// push sends all metrics over a new connection.
+func (gr *graphite) push() error {
+	var buf bytes.Buffer
+	if err := writeGraphite(&buf, gr.prefix, gather(gr.gatherer), time.Now()); err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", gr.address, gr.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if gr.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(gr.timeout))
+	}
+	_, err = buf.WriteTo(conn)
+	return err
+}
+
+// writeGraphite writes the metrics in the plaintext protocol. Paths consist
+// of the prefix, the metric name and the sorted label names and values, e.g.
+// consul.consul_catalog_kv.key.config_replicas. Summaries and histograms are
+// split into their series like in the text format.
+func writeGraphite(w io.Writer, prefix string, mfs []*dto.MetricFamily, now time.Time) error {
+	for _, ts := range newWriteRequest(mfs, now).Timeseries {
+		var path []string
+		if prefix != "" {
+			path = append(path, prefix)
+		}
+		for _, l := range ts.Labels {
+			if l.Name == model.MetricNameLabel {
+				// Labels are sorted, the name comes first.
+				path = append(path, graphiteSanitize(l.Value))
+				continue
+			}
+			path = append(path, graphiteSanitize(l.Name), graphiteSanitize(l.Value))
+		}
+		for _, s := range ts.Samples {
+			if _, err := fmt.Fprintf(w, "%s %g %d\n", strings.Join(path, "."), s.Value, s.Timestamp/1000); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// graphiteSanitize replaces the characters not allowed in path segments.
+func graphiteSanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == ' ' || r == '/' || r == '\\' || r == '\n' {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNewPushgateway(t *testing.T) {
+	pg, err := newPushgateway("localhost:9091/", "consul", []string{"site=edge/1", "dc=dc1"}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "http://localhost:9091/metrics/job/consul/dc/dc1/site/edge%2F1"; pg.url != expected {
+		t.Errorf("expected %s, got %s", expected, pg.url)
+	}
+
+	if _, err := newPushgateway("localhost:9091", "consul", []string{"site"}, 0, nil); err == nil {
+		t.Errorf("expected error for grouping label without value")
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return []*dto.MetricFamily{{
+			Name: proto.String("consul_up"),
+			Help: proto.String("Was the last query of Consul successful."),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				{Gauge: &dto.Gauge{Value: proto.Float64(1)}, TimestampMs: proto.Int64(1000)},
+			},
+		}}, nil
+	})
+	path := filepath.Join(dir, "consul.prom")
+	if err := writeTextfile(path, g); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# HELP consul_up Was the last query of Consul successful.\n# TYPE consul_up gauge\nconsul_up 1\n"
+	if string(content) != expected {
+		t.Errorf("expected %q, got %q", expected, content)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected temporary file to be removed, got %d files", len(files))
+	}
+}
+
+func TestWriteGraphite(t *testing.T) {
+	mfs := []*dto.MetricFamily{{
+		Name: proto.String("consul_catalog_kv"),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{
+			Label: []*dto.LabelPair{{Name: proto.String("key"), Value: proto.String("config/replicas")}},
+			Gauge: &dto.Gauge{Value: proto.Float64(3)},
+		}},
+	}}
+
+	var buf bytes.Buffer
+	if err := writeGraphite(&buf, "consul", mfs, time.Unix(1500000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "consul.consul_catalog_kv.key.config_replicas 3 1500000000\n"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
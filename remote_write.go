@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+
+	consul_api "github.com/hashicorp/consul/api"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// remoteWriteOpts configures pushing the collected metrics to a Prometheus
+// remote-write endpoint.
+type remoteWriteOpts struct {
+	url                string
+	username           string
+	password           string
+	bearerTokenFile    string
+	caFile             string
+	certFile           string
+	keyFile            string
+	serverName         string
+	insecureSkipVerify bool
+	timeout            time.Duration
+}
+
+// remoteWriter periodically gathers the metrics of a gatherer and sends them
+// to a remote-write endpoint.
+type remoteWriter struct {
+	opts     remoteWriteOpts
+	client   *http.Client
+	gatherer prometheus.Gatherer
+}
+
+func newRemoteWriter(opts remoteWriteOpts, g prometheus.Gatherer) (*remoteWriter, error) {
+	tlsConfig, err := consul_api.SetupTLSConfig(&consul_api.TLSConfig{
+		Address:            opts.serverName,
+		CAFile:             opts.caFile,
+		CertFile:           opts.certFile,
+		KeyFile:            opts.keyFile,
+		InsecureSkipVerify: opts.insecureSkipVerify,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote-write TLS config: %s", err)
+	}
+	return &remoteWriter{
+		opts: opts,
+		client: &http.Client{
+			Timeout:   opts.timeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+		gatherer: g,
+	}, nil
+}
+
+// write gathers the metrics and sends them in a single request.
+func (w *remoteWriter) write() error {
+	data, err := proto.Marshal(newWriteRequest(gather(w.gatherer), time.Now()))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.opts.url, bytes.NewReader(snappyEncode(data)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "consul_exporter/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.opts.username != "" {
+		req.SetBasicAuth(w.opts.username, w.opts.password)
+	}
+	if w.opts.bearerTokenFile != "" {
+		token, err := ioutil.ReadFile(w.opts.bearerTokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// The following types mirror the messages of Prometheus' remote-write
+// protocol (prompb), which aren't vendored.
+
+type prompbWriteRequest struct {
+	Timeseries []*prompbTimeSeries `protobuf:"bytes,1,rep,name=timeseries"`
+}
+
+func (m *prompbWriteRequest) Reset()         { *m = prompbWriteRequest{} }
+func (m *prompbWriteRequest) String() string { return proto.CompactTextString(m) }
+func (*prompbWriteRequest) ProtoMessage()    {}
+
+type prompbTimeSeries struct {
+	Labels  []*prompbLabel  `protobuf:"bytes,1,rep,name=labels"`
+	Samples []*prompbSample `protobuf:"bytes,2,rep,name=samples"`
+}
+
+func (m *prompbTimeSeries) Reset()         { *m = prompbTimeSeries{} }
+func (m *prompbTimeSeries) String() string { return proto.CompactTextString(m) }
+func (*prompbTimeSeries) ProtoMessage()    {}
+
+type prompbLabel struct {
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *prompbLabel) Reset()         { *m = prompbLabel{} }
+func (m *prompbLabel) String() string { return proto.CompactTextString(m) }
+func (*prompbLabel) ProtoMessage()    {}
+
+type prompbSample struct {
+	Value     float64 `protobuf:"fixed64,1,opt,name=value,proto3"`
+	Timestamp int64   `protobuf:"varint,2,opt,name=timestamp,proto3"`
+}
+
+func (m *prompbSample) Reset()         { *m = prompbSample{} }
+func (m *prompbSample) String() string { return proto.CompactTextString(m) }
+func (*prompbSample) ProtoMessage()    {}
+
+// newWriteRequest converts metric families to a write request. Summaries and
+// histograms are split into their series like in the text format. Samples
+// without an explicit timestamp are sent with now.
+func newWriteRequest(mfs []*dto.MetricFamily, now time.Time) *prompbWriteRequest {
+	req := &prompbWriteRequest{}
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			ts := now.UnixNano() / int64(time.Millisecond)
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...string) {
+				labels := []*prompbLabel{{Name: model.MetricNameLabel, Value: name + suffix}}
+				for _, lp := range m.Label {
+					labels = append(labels, &prompbLabel{Name: lp.GetName(), Value: lp.GetValue()})
+				}
+				for i := 0; i < len(extra); i += 2 {
+					labels = append(labels, &prompbLabel{Name: extra[i], Value: extra[i+1]})
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+				req.Timeseries = append(req.Timeseries, &prompbTimeSeries{
+					Labels:  labels,
+					Samples: []*prompbSample{{Value: value, Timestamp: ts}},
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.GetSummary().Quantile {
+					add("", q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add("_sum", m.GetSummary().GetSampleSum())
+				add("_count", float64(m.GetSummary().GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				for _, b := range m.GetHistogram().Bucket {
+					add("_bucket", float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				add("_bucket", float64(m.GetHistogram().GetSampleCount()), "le", "+Inf")
+				add("_sum", m.GetHistogram().GetSampleSum())
+				add("_count", float64(m.GetHistogram().GetSampleCount()))
+			}
+		}
+	}
+	return req
+}
+
+// formatFloat formats quantiles and bucket bounds like the text format.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(f)
+}
+
+// snappyEncode encodes data in the snappy block format using literals only.
+// The result isn't compressed, but is valid input for any snappy decoder,
+// which is all remote-write receivers require.
+func snappyEncode(data []byte) []byte {
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(data)))])
+
+	const maxLiteral = 1 << 16
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxLiteral {
+			n = maxLiteral
+		}
+		switch l := n - 1; {
+		case l < 60:
+			buf.WriteByte(byte(l) << 2)
+		case l < 1<<8:
+			buf.WriteByte(60 << 2)
+			buf.WriteByte(byte(l))
+		default:
+			buf.WriteByte(61 << 2)
+			buf.WriteByte(byte(l))
+			buf.WriteByte(byte(l >> 8))
+		}
+		buf.Write(data[:n])
+		data = data[n:]
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNewWriteRequest(t *testing.T) {
+	mfs := []*dto.MetricFamily{
+		{
+			Name: proto.String("consul_up"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				{Gauge: &dto.Gauge{Value: proto.Float64(1)}},
+			},
+		},
+		{
+			Name: proto.String("consul_catalog_kv"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				{
+					Label:       []*dto.LabelPair{{Name: proto.String("key"), Value: proto.String("a")}},
+					Gauge:       &dto.Gauge{Value: proto.Float64(42)},
+					TimestampMs: proto.Int64(1000),
+				},
+			},
+		},
+		{
+			Name: proto.String("rpc_duration_seconds"),
+			Type: dto.MetricType_SUMMARY.Enum(),
+			Metric: []*dto.Metric{
+				{Summary: &dto.Summary{
+					SampleCount: proto.Uint64(3),
+					SampleSum:   proto.Float64(1.5),
+					Quantile:    []*dto.Quantile{{Quantile: proto.Float64(0.5), Value: proto.Float64(0.4)}},
+				}},
+			},
+		},
+	}
+
+	req := newWriteRequest(mfs, time.Unix(2, 0))
+	var got []string
+	for _, ts := range req.Timeseries {
+		got = append(got, proto.CompactTextString(ts))
+	}
+	expected := []string{
+		`labels:<name:"__name__" value:"consul_up" > samples:<value:1 timestamp:2000 > `,
+		`labels:<name:"__name__" value:"consul_catalog_kv" > labels:<name:"key" value:"a" > samples:<value:42 timestamp:1000 > `,
+		`labels:<name:"__name__" value:"rpc_duration_seconds" > labels:<name:"quantile" value:"0.5" > samples:<value:0.4 timestamp:2000 > `,
+		`labels:<name:"__name__" value:"rpc_duration_seconds_sum" > samples:<value:1.5 timestamp:2000 > `,
+		`labels:<name:"__name__" value:"rpc_duration_seconds_count" > samples:<value:3 timestamp:2000 > `,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSnappyEncode(t *testing.T) {
+	data := make([]byte, 70000)
+	encoded := snappyEncode(data)
+	// Length varint, then literals of 65536 and 4464 bytes.
+	if expected := 3 + 3 + 65536 + 3 + 4464; len(encoded) != expected {
+		t.Errorf("expected %d bytes, got %d", expected, len(encoded))
+	}
+	if encoded[3] != 61<<2 || encoded[4] != 0xff || encoded[5] != 0xff {
+		t.Errorf("unexpected tag of first literal: %x", encoded[3:6])
+	}
+}
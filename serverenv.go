@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// serverFromEnv replaces the host of the Consul server URI with the value of
+// the environment variable env, keeping its scheme and port. In Kubernetes,
+// the variable can hold the node's IP from the downward API, so that the
+// pods of a DaemonSet talk to the agent of their node.
+func serverFromEnv(uri, env string) (string, error) {
+	host := os.Getenv(env)
+	if host == "" {
+		return "", fmt.Errorf("environment variable %s is empty", env)
+	}
+
+	scheme := "http"
+	if i := strings.Index(uri, "://"); i >= 0 {
+		scheme, uri = uri[:i], uri[i+3:]
+	}
+	u, err := url.Parse(scheme + "://" + uri)
+	if err != nil {
+		return "", err
+	}
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+	return u.String(), nil
+}
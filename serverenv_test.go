@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestServerFromEnv(t *testing.T) {
+	defer os.Unsetenv("CONSUL_EXPORTER_TEST_HOST_IP")
+
+	if _, err := serverFromEnv("http://localhost:8500", "CONSUL_EXPORTER_TEST_HOST_IP"); err == nil {
+		t.Errorf("expected error for unset environment variable")
+	}
+
+	for host, cases := range map[string]map[string]string{
+		"10.0.0.5": {
+			"http://localhost:8500": "http://10.0.0.5:8500",
+			"https://consul:8501":   "https://10.0.0.5:8501",
+			"localhost:8500":        "http://10.0.0.5:8500",
+			"https://consul":        "https://10.0.0.5",
+		},
+		"fd00::5": {
+			"http://localhost:8500": "http://[fd00::5]:8500",
+			"http://localhost":      "http://[fd00::5]",
+		},
+	} {
+		os.Setenv("CONSUL_EXPORTER_TEST_HOST_IP", host)
+		for uri, want := range cases {
+			got, err := serverFromEnv(uri, "CONSUL_EXPORTER_TEST_HOST_IP")
+			if err != nil || got != want {
+				t.Errorf("expected %s for %s with host %s, got %s (%v)", want, uri, host, got, err)
+			}
+		}
+	}
+}